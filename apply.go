@@ -0,0 +1,156 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// apply.go runs generated SQL against db2
+//
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// statementEndRegex matches the end of a statement outside of a STATEMENT-BEGIN/END
+// block: a semicolon at the end of the line, optionally followed by a comment (like
+// the -- Add label on grants)
+var statementEndRegex = regexp.MustCompile(`;\s*(--[^']*)?$`)
+
+// splitSql splits a script written by renderSql (and possibly edited since) into
+// statements.  Everything between -- STATEMENT-BEGIN and -- STATEMENT-END is one
+// statement, no matter how many lines or semicolons it has.  Elsewhere a statement
+// ends with the line that ends with a semicolon, and comment lines are skipped.
+func splitSql(script string) []string {
+	var statements []string
+	var lines []string
+	inBlock := false
+
+	add := func() {
+		stmt := strings.TrimSpace(strings.Join(lines, "\n"))
+		stmt = strings.TrimSpace(strings.TrimSuffix(stmt, ";"))
+		if len(stmt) > 0 {
+			statements = append(statements, stmt)
+		}
+		lines = nil
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "-- STATEMENT-BEGIN"):
+			add()
+			inBlock = true
+		case strings.HasPrefix(trimmed, "-- STATEMENT-END"):
+			add()
+			inBlock = false
+		case inBlock:
+			lines = append(lines, line)
+		case len(trimmed) == 0 || strings.HasPrefix(trimmed, "--"):
+			// skip comments and blank lines between statements
+		case statementEndRegex.MatchString(line):
+			lines = append(lines, statementEndRegex.ReplaceAllString(line, ";"))
+			add()
+		default:
+			lines = append(lines, line)
+		}
+	}
+	// A last statement without a semicolon
+	add()
+	return statements
+}
+
+// applyFailure describes a statement that failed when it was applied
+type applyFailure struct {
+	number    int // the position of the statement in the script, starting at 1
+	statement string
+	err       error
+}
+
+// String reports the statement with the Postgres error and SQLSTATE
+func (f applyFailure) String() string {
+	msg := f.err.Error()
+	var pqErr *pq.Error
+	if errors.As(f.err, &pqErr) {
+		msg = fmt.Sprintf("%s: %s (SQLSTATE %s)", pqErr.Severity, pqErr.Message, pqErr.Code)
+		if len(pqErr.Detail) > 0 {
+			msg += "\nDETAIL: " + pqErr.Detail
+		}
+		if len(pqErr.Hint) > 0 {
+			msg += "\nHINT: " + pqErr.Hint
+		}
+	}
+	return fmt.Sprintf("Statement %d failed:\n%s\n%s", f.number, f.statement, msg)
+}
+
+// applyStatements runs the statements against the database in one transaction.  By
+// default the first failure rolls back everything.  When continueOnError is true,
+// each statement runs inside a savepoint so a failure only undoes that statement,
+// and the statements that succeeded are committed.
+func applyStatements(conn *sql.DB, statements []string, continueOnError bool) (failures []applyFailure, committed bool, err error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i, stmt := range statements {
+		if continueOnError {
+			if _, err := tx.Exec("SAVEPOINT pgdiff_statement"); err != nil {
+				tx.Rollback()
+				return failures, false, err
+			}
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			failures = append(failures, applyFailure{number: i + 1, statement: stmt, err: err})
+			if !continueOnError {
+				return failures, false, tx.Rollback()
+			}
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT pgdiff_statement"); err != nil {
+				tx.Rollback()
+				return failures, false, err
+			}
+			continue
+		}
+		if continueOnError {
+			if _, err := tx.Exec("RELEASE SAVEPOINT pgdiff_statement"); err != nil {
+				tx.Rollback()
+				return failures, false, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return failures, false, err
+	}
+	return failures, true, nil
+}
+
+// applyScript splits the script, applies it to the database, and reports the result.
+// It returns true if every statement was applied.
+func applyScript(w io.Writer, conn *sql.DB, script string, continueOnError bool) bool {
+	statements := splitSql(script)
+	failures, committed, err := applyStatements(conn, statements, continueOnError)
+	for _, failure := range failures {
+		fmt.Fprintln(w, failure)
+		fmt.Fprintln(w)
+	}
+	if err != nil {
+		fmt.Fprintln(w, "Error!!!, applying the SQL:", err)
+	}
+	switch {
+	case !committed:
+		fmt.Fprintln(w, "Rolled back.  No statements were applied.")
+	case len(failures) > 0:
+		fmt.Fprintf(w, "Applied %d of %d statements.  %d failed and were skipped.\n", len(statements)-len(failures), len(statements), len(failures))
+	default:
+		fmt.Fprintf(w, "Applied %d statements.\n", len(statements))
+	}
+	return committed && len(failures) == 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func Test_splitSql(t *testing.T) {
+	changes := []*SchemaChange{
+		{Kind: "FUNCTION", Action: ActionChange, Warnings: []string{"This function is different"},
+			Statements: []string{"CREATE OR REPLACE FUNCTION s1.f1()\n AS $$ SELECT 1; $$"}},
+		{Kind: "GRANT_RELATIONSHIP", Action: ActionAdd, Statements: []string{"GRANT SELECT ON s1.t1 TO u1"}},
+		{Kind: "TABLE", Action: ActionDrop, Statements: []string{"DROP TABLE s1.t2"}},
+	}
+	script := new(bytes.Buffer)
+	script.WriteString("-- schemaType: ALL\n")
+	renderSql(script, changes)
+	// Statements edited by hand can span lines
+	script.WriteString("ALTER TABLE s1.t3\n    ADD COLUMN c1 text;\n\nDROP VIEW s1.v1")
+
+	expected := []string{
+		"CREATE OR REPLACE FUNCTION s1.f1()\n AS $$ SELECT 1; $$",
+		"GRANT SELECT ON s1.t1 TO u1",
+		"DROP TABLE s1.t2",
+		"ALTER TABLE s1.t3\n    ADD COLUMN c1 text",
+		"DROP VIEW s1.v1",
+	}
+	statements := splitSql(script.String())
+	if len(statements) != len(expected) {
+		t.Fatalf("Wrong number of statements: %q", statements)
+	}
+	for i, stmt := range statements {
+		if stmt != expected[i] {
+			t.Errorf("Statement %d is %q instead of %q", i, stmt, expected[i])
+		}
+	}
+}
+
+func Test_applyFailureString(t *testing.T) {
+	failure := applyFailure{number: 2, statement: "CREATE TABLE s1.t1()",
+		err: &pq.Error{Severity: "ERROR", Code: "42P07", Message: `relation "t1" already exists`}}
+	msg := failure.String()
+	if !strings.Contains(msg, "Statement 2 failed") || !strings.Contains(msg, "(SQLSTATE 42P07)") {
+		t.Errorf("Wrong failure message: %s", msg)
+	}
+
+	failure.err = errors.New("connection reset")
+	if !strings.HasSuffix(failure.String(), "\nconnection reset") {
+		t.Errorf("Wrong failure message: %s", failure.String())
+	}
+}
@@ -0,0 +1,217 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// catalog.go defines where the rows of the catalog queries come from: either a live
+// database connection or a snapshot file (see snapshot.go)
+//
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/joncrlsn/pgutil"
+	"github.com/lib/pq"
+)
+
+// isoFormat is how timestamps are written in the rows (the same as pgutil uses)
+const isoFormat = "2006-01-02T15:04:05.000-0700"
+
+// minServerVersion is the oldest Postgres the catalog queries run on (as in
+// server_version_num).  They read pg_proc.prokind, which is new in 11.  Columns that
+// are newer than that are read with to_jsonb() so the queries still run without them.
+const minServerVersion = 110000
+
+// catalogQueries names every catalog query pgdiff runs.  The names are the keys
+// under which a snapshot file stores the rows of each query.
+var catalogQueries = map[string]*template.Template{
+	"SCHEMA":               schemataSqlTemplate,
+	"EXTENSION":            extensionSqlTemplate,
+	"ROLE":                 roleSqlTemplate,
+	"SEQUENCE":             sequenceSqlTemplate,
+	"TYPE":                 typeSqlTemplate,
+	"TABLE":                tableSqlTemplate,
+	"COLUMN":               columnSqlTemplate,
+	"TABLE_COLUMN":         tableColumnSqlTemplate,
+	"INDEX":                indexSqlTemplate,
+	"VIEW":                 viewSqlTemplate,
+	"MATVIEW":              matViewSqlTemplate,
+	"FOREIGN_KEY":          foreignKeySqlTemplate,
+	"CHECK_CONSTRAINT":     checkConstraintSqlTemplate,
+	"FUNCTION":             functionSqlTemplate,
+	"TRIGGER":              triggerSqlTemplate,
+	"POLICY":               policySqlTemplate,
+	"OWNER":                ownerSqlTemplate,
+	"GRANT_RELATIONSHIP":   grantRelationshipSqlTemplate,
+	"GRANT_ATTRIBUTE":      grantAttributeSqlTemplate,
+	"GRANT_SCHEMA":         grantSchemaSqlTemplate,
+	"GRANT_FUNCTION":       grantFunctionSqlTemplate,
+	"GRANT_TYPE":           grantTypeSqlTemplate,
+	"GRANT_LANGUAGE":       grantLanguageSqlTemplate,
+	"GRANT_DATABASE":       grantDatabaseSqlTemplate,
+	"GRANT_FOREIGN_SERVER": grantForeignServerSqlTemplate,
+	"DEFAULT_PRIVILEGE":    defaultPrivilegeSqlTemplate,
+	"COMMENT":              commentSqlTemplate,
+	"KEYWORD":              keywordSqlTemplate,
+	"DEPENDENCY":           dependencySqlTemplate,
+}
+
+// Catalog supplies the catalog rows for one side of a comparison
+type Catalog interface {
+	// Query returns the rows of the named catalog query.  The template is executed
+	// against the catalogParams for this side when the rows come from a database.
+	Query(name string, tpl *template.Template) []map[string]string
+}
+
+// catalogParams is what the catalog query templates are executed against.  The
+// templates only decide the shape of the SQL; schema names are never written into
+// it, they are bound as query parameters.
+type catalogParams struct {
+	DbSchema string // a schema name, or * when more than one schema is compared
+	schemas  []string
+	args     []interface{}
+}
+
+// SchemaFilter returns the condition that limits the given schema column to the
+// schemas being compared.  With no list of schemas, every schema except the system
+// schemas is compared.
+func (p *catalogParams) SchemaFilter(column string) string {
+	if len(p.schemas) == 0 {
+		return column + " NOT LIKE 'pg_%' AND " + column + " <> 'information_schema'"
+	}
+	if len(p.args) == 0 {
+		p.args = append(p.args, pq.Array(p.schemas))
+	}
+	return column + " = ANY($1::text[])"
+}
+
+// ExtensionFilter returns the condition that leaves out an object that belongs to an
+// extension, given the system catalog it is stored in and the expression for its oid.
+// Those objects are created by CREATE EXTENSION (see extension.go).
+func (p *catalogParams) ExtensionFilter(catalog string, oid string) string {
+	return "NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend AS ext WHERE ext.classid = 'pg_catalog." + catalog +
+		"'::regclass AND ext.objid = " + oid + " AND ext.deptype = 'e')"
+}
+
+// parseSchemas splits the value of --schema1/--schema2 (a schema name, a comma
+// separated list of names, or *) into schema names.  It returns nil for *.
+func parseSchemas(dbSchema string) []string {
+	var schemas []string
+	for _, schema := range strings.Split(dbSchema, ",") {
+		schema = strings.TrimSpace(schema)
+		if len(schema) > 0 {
+			schemas = append(schemas, schema)
+		}
+	}
+	if len(schemas) == 1 && schemas[0] == "*" {
+		return nil
+	}
+	return schemas
+}
+
+// dbCatalog reads catalog rows from a live database
+type dbCatalog struct {
+	conn    *sql.DB
+	dbInfo  *pgutil.DbInfo
+	schemas []string // nil for all schemas
+}
+
+// newDbCatalog opens a connection to the given database.  When dbInfo names more
+// than one schema, its DbSchema is changed to * because objects from several schemas
+// are compared by their schema-qualified names, just like with *.
+func newDbCatalog(dbInfo *pgutil.DbInfo) *dbCatalog {
+	schemas := parseSchemas(dbInfo.DbSchema)
+	if len(schemas) == 1 {
+		dbInfo.DbSchema = schemas[0]
+	} else {
+		dbInfo.DbSchema = "*"
+	}
+
+	conn, err := dbInfo.Open()
+	check("opening database "+dbInfo.DbName, err)
+
+	var version int
+	check("reading the server version of "+dbInfo.DbName, conn.QueryRow("SELECT current_setting('server_version_num')::integer").Scan(&version))
+	check("checking the server version of "+dbInfo.DbName, checkServerVersion(version))
+	return &dbCatalog{conn: conn, dbInfo: dbInfo, schemas: schemas}
+}
+
+// checkServerVersion returns an error when the server is older than pgdiff supports
+func checkServerVersion(version int) error {
+	if version < minServerVersion {
+		return fmt.Errorf(": PostgreSQL %d or later is required, but the server_version_num is %d", minServerVersion/10000, version)
+	}
+	return nil
+}
+
+func (c *dbCatalog) Query(name string, tpl *template.Template) []map[string]string {
+	params := &catalogParams{DbSchema: c.dbInfo.DbSchema, schemas: c.schemas}
+	buf := new(bytes.Buffer)
+	check("building the "+name+" query", tpl.Execute(buf, params))
+
+	return queryStrings(c.conn, buf.String(), params.args...)
+}
+
+// queryStrings runs the query with the given bind parameters and returns the rows as
+// string maps keyed by column name.  Values are converted the same way as
+// pgutil.QueryStrings does, so NULL is "null".
+func queryStrings(conn *sql.DB, query string, args ...interface{}) []map[string]string {
+	rs, err := conn.Query(query, args...)
+	check("running query", err)
+	defer rs.Close()
+
+	columnNames, err := rs.Columns()
+	check("getting column names", err)
+
+	vals := make([]interface{}, len(columnNames))
+	valPointers := make([]interface{}, len(columnNames))
+	for i := range vals {
+		valPointers[i] = &vals[i]
+	}
+
+	rows := make([]map[string]string, 0)
+	for rs.Next() {
+		check("scanning a row", rs.Scan(valPointers...))
+
+		row := make(map[string]string)
+		for i, val := range vals {
+			switch val := val.(type) {
+			case nil:
+				row[columnNames[i]] = "null"
+			case []byte:
+				row[columnNames[i]] = string(val)
+			case string:
+				row[columnNames[i]] = val
+			case int64:
+				row[columnNames[i]] = fmt.Sprintf("%d", val)
+			case float64:
+				row[columnNames[i]] = fmt.Sprintf("%f", val)
+			case bool:
+				row[columnNames[i]] = fmt.Sprintf("%t", val)
+			case time.Time:
+				row[columnNames[i]] = val.Format(isoFormat)
+			default:
+				row[columnNames[i]] = fmt.Sprintf("%v", val)
+			}
+		}
+		rows = append(rows, row)
+	}
+	check("reading rows", rs.Err())
+	return rows
+}
+
+// openCatalog returns a catalog that reads the snapshot file when one is given and
+// the database otherwise
+func openCatalog(dbInfo *pgutil.DbInfo, snapshotFile string) Catalog {
+	if len(snapshotFile) > 0 {
+		return newSnapshotCatalog(snapshotFile, dbInfo)
+	}
+	return newDbCatalog(dbInfo)
+}
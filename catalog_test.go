@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_checkServerVersion(t *testing.T) {
+	if err := checkServerVersion(110022); err != nil {
+		t.Errorf("PostgreSQL 11 should be supported: %v", err)
+	}
+	if err := checkServerVersion(100023); err == nil {
+		t.Errorf("PostgreSQL 10 should not be supported")
+	}
+}
+
+func Test_parseSchemas(t *testing.T) {
+	tests := []struct {
+		dbSchema string
+		expected []string
+	}{
+		{"*", nil},
+		{"", nil},
+		{"public", []string{"public"}},
+		{"public, audit ,", []string{"public", "audit"}},
+		{"it's", []string{"it's"}},
+	}
+	for _, test := range tests {
+		schemas := parseSchemas(test.dbSchema)
+		if strings.Join(schemas, "|") != strings.Join(test.expected, "|") || len(schemas) != len(test.expected) {
+			t.Errorf("parseSchemas(%q) is %q instead of %q", test.dbSchema, schemas, test.expected)
+		}
+	}
+}
+
+// Schema names must be bound as parameters, not written into the SQL
+func Test_catalogQueriesBindSchemas(t *testing.T) {
+	for name, tpl := range catalogQueries {
+		params := &catalogParams{DbSchema: "it's", schemas: []string{"it's"}}
+		buf := new(bytes.Buffer)
+		if err := tpl.Execute(buf, params); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sql := buf.String()
+		if strings.Contains(sql, "it's") {
+			t.Errorf("%s query contains the schema name:\n%s", name, sql)
+		}
+		if (len(params.args) == 1) != strings.Contains(sql, "$1") {
+			t.Errorf("%s query has %d args but $1 is used: %v", name, len(params.args), strings.Contains(sql, "$1"))
+		}
+
+		// Objects that belong to extensions are created by CREATE EXTENSION.  Databases
+		// and default privileges never belong to one, the privileges on languages are
+		// compared anyway, and keywords are not objects.
+		if name != "ROLE" && name != "EXTENSION" && name != "GRANT_DATABASE" && name != "GRANT_LANGUAGE" &&
+			name != "DEFAULT_PRIVILEGE" && name != "KEYWORD" && !strings.Contains(sql, "deptype = 'e'") {
+			t.Errorf("%s query should leave out extension objects", name)
+		}
+
+		all := &catalogParams{DbSchema: "*"}
+		buf.Reset()
+		if err := tpl.Execute(buf, all); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(all.args) > 0 || strings.Contains(buf.String(), "$1") {
+			t.Errorf("%s query for all schemas should not have parameters", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// change.go defines the change records returned by the Add, Drop, and Change
+// methods of each Schema implementation
+//
+
+package main
+
+import (
+	"fmt"
+)
+
+// The actions a SchemaChange can take on an object in db2
+const (
+	ActionAdd    = "ADD"
+	ActionDrop   = "DROP"
+	ActionChange = "CHANGE"
+)
+
+// SchemaChange describes one difference between the two databases and the SQL that
+// makes db2 match db1.
+type SchemaChange struct {
+	Kind        string            `json:"type"`        // the schema type that found the difference (TABLE, COLUMN, etc)
+	Schema      string            `json:"schema"`      // the schema of the object in db2, if it has one
+	Name        string            `json:"object"`      // the qualified name of the object
+	Action      string            `json:"action"`      // ActionAdd, ActionDrop, or ActionChange
+	DB1         map[string]string `json:"db1"`         // the catalog row for the object in db1, nil when it is missing
+	DB2         map[string]string `json:"db2"`         // the catalog row for the object in db2, nil when it is missing
+	Statements  []string          `json:"sql"`         // SQL statements, without their terminating semicolons
+	Warnings    []string          `json:"warnings"`    // things to check before running the statements
+	Destructive bool              `json:"destructive"` // true if the statements drop objects or may lose data
+
+	key string // identifies the object when ordering by dependency (see depend.go)
+}
+
+// newChange returns an empty change for the named object.  The schema is prepended
+// to the name unless it is empty.
+func newChange(kind string, action string, schema string, name string) *SchemaChange {
+	qualifiedName := name
+	if len(schema) > 0 {
+		qualifiedName = schema + "." + name
+	}
+	return &SchemaChange{Kind: kind, Schema: schema, Name: qualifiedName, Action: action}
+}
+
+// addSql appends a formatted SQL statement to the change
+func (c *SchemaChange) addSql(format string, a ...interface{}) {
+	c.Statements = append(c.Statements, fmt.Sprintf(format, a...))
+}
+
+// addWarning appends a formatted warning to the change
+func (c *SchemaChange) addWarning(format string, a ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, a...))
+}
+
+// isEmpty tells you whether the change has nothing to say
+func (c *SchemaChange) isEmpty() bool {
+	return len(c.Statements) == 0 && len(c.Warnings) == 0
+}
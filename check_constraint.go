@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/joncrlsn/misc"
+)
+
+var (
+	checkConstraintSqlTemplate = initCheckConstraintSqlTemplate()
+)
+
+// Initializes the Sql template
+func initCheckConstraintSqlTemplate() *template.Template {
+	sql := `
+SELECT {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}cl.relname || '.' || c.conname AS compare_name
+    , n.nspname AS schema_name
+    , cl.relname AS table_name
+    , c.conname AS constraint_name
+    , pg_catalog.pg_get_constraintdef(c.oid, true) AS constraint_def
+    , c.convalidated AS validated
+FROM pg_catalog.pg_constraint AS c
+INNER JOIN pg_catalog.pg_class AS cl ON (cl.oid = c.conrelid)
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = cl.relnamespace)
+WHERE c.contype = 'c'
+AND c.conislocal
+AND {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "cl.oid"}}
+`
+	t := template.New("CheckConstraintSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// CheckConstraintRows definition
+// ==================================
+
+// CheckConstraintRows is a sortable slice of string maps
+type CheckConstraintRows []map[string]string
+
+func (slice CheckConstraintRows) Len() int {
+	return len(slice)
+}
+
+func (slice CheckConstraintRows) Less(i, j int) bool {
+	return slice[i]["compare_name"] < slice[j]["compare_name"]
+}
+
+func (slice CheckConstraintRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// CheckConstraintSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// CheckConstraintSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type CheckConstraintSchema struct {
+	rows   CheckConstraintRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *CheckConstraintSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *CheckConstraintSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *CheckConstraintSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*CheckConstraintSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a CheckConstraintSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	return val
+}
+
+// ObjectKey identifies the check constraint in the current row when ordering by dependency
+func (c *CheckConstraintSchema) ObjectKey() string {
+	return "check_constraint:" + c.get("schema_name") + "." + c.get("table_name") + "." + c.get("constraint_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *CheckConstraintSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// checkDef returns the CHECK (...) expression without the NOT VALID that
+// pg_get_constraintdef() appends to constraints that have not been validated
+func (c *CheckConstraintSchema) checkDef() string {
+	return strings.TrimSuffix(c.get("constraint_def"), " NOT VALID")
+}
+
+// Add returns SQL to add the check constraint
+func (c *CheckConstraintSchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("schema_name")
+	}
+	change := newChange("CHECK_CONSTRAINT", ActionAdd, schema, c.get("table_name")+"."+c.get("constraint_name"))
+	c.addCreate(change, schema)
+	return change
+}
+
+// addCreate adds the SQL to create the check constraint in the given schema.  The
+// constraint is added NOT VALID and then validated, so the existing rows are checked
+// without blocking writes to the table for the whole scan.  A constraint that is not
+// valid in db1 is left that way.
+func (c *CheckConstraintSchema) addCreate(change *SchemaChange, schema string) {
+	table := qualifiedName(schema, c.get("table_name"))
+	change.addSql("ALTER TABLE %s ADD CONSTRAINT %s %s NOT VALID", table, quoteIdent(c.get("constraint_name")), c.checkDef())
+	if c.get("validated") == "true" {
+		change.addSql("ALTER TABLE %s VALIDATE CONSTRAINT %s", table, quoteIdent(c.get("constraint_name")))
+	}
+}
+
+// Drop returns SQL to drop the check constraint
+func (c *CheckConstraintSchema) Drop() *SchemaChange {
+	change := newChange("CHECK_CONSTRAINT", ActionDrop, c.get("schema_name"), c.get("table_name")+"."+c.get("constraint_name"))
+	change.Destructive = true
+	change.addWarning("Dropping %s", c.get("constraint_def"))
+	c.addDrop(change)
+	return change
+}
+
+// addDrop adds the SQL to drop the check constraint
+func (c *CheckConstraintSchema) addDrop(change *SchemaChange) {
+	change.addSql("ALTER TABLE %s DROP CONSTRAINT %s", qualifiedName(c.get("schema_name"), c.get("table_name")), quoteIdent(c.get("constraint_name")))
+}
+
+// Change replaces the check constraint when its expression is different, and
+// validates it when it is only valid in db1
+func (c *CheckConstraintSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*CheckConstraintSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, CheckConstraintSchema.Change(obj) needs a CheckConstraintSchema instance", c2)
+	}
+	change := newChange("CHECK_CONSTRAINT", ActionChange, c2.get("schema_name"), c.get("table_name")+"."+c.get("constraint_name"))
+
+	if c.checkDef() != c2.checkDef() {
+		change.addWarning("CHANGE: Different check constraints on %s:", c.get("table_name"))
+		change.addWarning("   %s", c.get("constraint_def"))
+		change.addWarning("   %s", c2.get("constraint_def"))
+		c2.addDrop(change)
+		c.addCreate(change, c2.get("schema_name"))
+	} else if c.get("validated") == "true" && c2.get("validated") != "true" {
+		change.addSql("ALTER TABLE %s VALIDATE CONSTRAINT %s", qualifiedName(c2.get("schema_name"), c2.get("table_name")), quoteIdent(c2.get("constraint_name")))
+	}
+	return change
+}
+
+// compareCheckConstraints returns the changes needed to make the check constraints
+// match between two databases or schemas
+func compareCheckConstraints(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := CheckConstraintRows(cat1.Query("CHECK_CONSTRAINT", checkConstraintSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := CheckConstraintRows(cat2.Query("CHECK_CONSTRAINT", checkConstraintSqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here
+	var schema1 Schema = &CheckConstraintSchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &CheckConstraintSchema{rows: rows2, rowNum: -1}
+
+	// Compare the check constraints, leaving out the validated ones of new tables
+	// because CREATE TABLE adds them (see addedTables)
+	added := addedTables(cat1, cat2)
+	var changes []*SchemaChange
+	for _, change := range doDiff(schema1, schema2) {
+		if change.Action == ActionAdd && change.DB1["validated"] == "true" && added[change.DB1["schema_name"]+"."+change.DB1["table_name"]] {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_CheckConstraintSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row := map[string]string{"schema_name": "s1", "table_name": "t1", "constraint_name": "t1_qty_check",
+		"constraint_def": "CHECK (qty > 0)", "validated": "true"}
+	notValid := map[string]string{"schema_name": "s1", "table_name": "t1", "constraint_name": "t1_qty_check",
+		"constraint_def": "CHECK (qty > 0) NOT VALID", "validated": "false"}
+	changed := map[string]string{"schema_name": "s1", "table_name": "t1", "constraint_name": "t1_qty_check",
+		"constraint_def": "CHECK (qty >= 0)", "validated": "true"}
+
+	c := &CheckConstraintSchema{rows: CheckConstraintRows{row}, rowNum: 0}
+	expectStatements(t, c.Add(),
+		"ALTER TABLE s1.t1 ADD CONSTRAINT t1_qty_check CHECK (qty > 0) NOT VALID",
+		"ALTER TABLE s1.t1 VALIDATE CONSTRAINT t1_qty_check")
+	expectStatements(t, c.Drop(), "ALTER TABLE s1.t1 DROP CONSTRAINT t1_qty_check")
+
+	// A constraint that isn't valid in db1 is added without validating it
+	c1 := &CheckConstraintSchema{rows: CheckConstraintRows{notValid}, rowNum: 0}
+	expectStatements(t, c1.Add(), "ALTER TABLE s1.t1 ADD CONSTRAINT t1_qty_check CHECK (qty > 0) NOT VALID")
+
+	// Only validated in db1
+	c2 := &CheckConstraintSchema{rows: CheckConstraintRows{notValid}, rowNum: 0}
+	expectStatements(t, c.Change(c2), "ALTER TABLE s1.t1 VALIDATE CONSTRAINT t1_qty_check")
+	if change := c1.Change(c); !change.isEmpty() {
+		t.Errorf("A constraint can't be made NOT VALID again: %q", change.Statements)
+	}
+
+	// A different expression replaces the constraint
+	c2 = &CheckConstraintSchema{rows: CheckConstraintRows{changed}, rowNum: 0}
+	expectStatements(t, c.Change(c2),
+		"ALTER TABLE s1.t1 DROP CONSTRAINT t1_qty_check",
+		"ALTER TABLE s1.t1 ADD CONSTRAINT t1_qty_check CHECK (qty > 0) NOT VALID",
+		"ALTER TABLE s1.t1 VALIDATE CONSTRAINT t1_qty_check")
+}
@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -37,13 +35,12 @@ SELECT table_schema
     , identity_generation
     , substring(udt_name from 2) AS array_type
 FROM information_schema.columns
+INNER JOIN pg_catalog.pg_class AS c ON (c.oid = format('%I.%I', table_schema, table_name)::regclass)
 WHERE is_updatable = 'YES'
-{{if eq $.DbSchema "*" }}
-AND table_schema NOT LIKE 'pg_%' 
-AND table_schema <> 'information_schema' 
-{{else}}
-AND table_schema = '{{$.DbSchema}}'
-{{end}}
+-- Partitions get their columns from the partitioned table
+AND NOT c.relispartition
+AND {{$.SchemaFilter "table_schema"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
 ORDER BY compare_name ASC;
 `
 	t := template.New("ColumnSqlTmpl")
@@ -71,16 +68,11 @@ INNER JOIN information_schema.tables b
     ON a.table_schema = b.table_schema AND
        a.table_name = b.table_name AND
        b.table_type = 'BASE TABLE'
+INNER JOIN pg_catalog.pg_class AS c ON (c.oid = format('%I.%I', a.table_schema, a.table_name)::regclass)
 WHERE is_updatable = 'YES'
-{{if eq $.DbSchema "*" }}
-AND a.table_schema NOT LIKE 'pg_%' 
-AND a.table_schema <> 'information_schema' 
-{{else}}
-AND a.table_schema = '{{$.DbSchema}}'
-{{end}}
-{{ if $.TableType }}
-AND b.table_type = '{{ $.TableType }}'
-{{ end }}
+AND NOT c.relispartition
+AND {{$.SchemaFilter "a.table_schema"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
 ORDER BY compare_name ASC;
 `
 	t := template.New("ColumnSqlTmpl")
@@ -141,33 +133,48 @@ func (c *ColumnSchema) NextRow() bool {
 func (c *ColumnSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*ColumnSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare needs a ColumnSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a ColumnSchema instance", c2)
 	}
 
 	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
 	return val
 }
 
-// Add prints SQL to add the column
-func (c *ColumnSchema) Add() {
+// ObjectKey identifies the column in the current row when ordering by dependency
+func (c *ColumnSchema) ObjectKey() string {
+	return "column:" + c.get("table_schema") + "." + c.get("table_name") + "." + c.get("column_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *ColumnSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to add the column
+func (c *ColumnSchema) Add() *SchemaChange {
 
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("table_schema")
 	}
+	change := newChange("COLUMN", ActionAdd, schema, c.get("table_name")+"."+c.get("column_name"))
 
 	// Knowing the version of db2 would eliminate the need for this warning
 	if c.get("is_identity") == "YES" {
-		fmt.Println("-- WARNING: identity columns are not supported in PostgreSQL versions < 10.")
-		fmt.Println("-- Attempting to create identity columns in earlier versions will probably result in errors.")
+		change.addWarning("WARNING: identity columns are not supported in PostgreSQL versions < 10.")
+		change.addWarning("Attempting to create identity columns in earlier versions will probably result in errors.")
 	}
 
+	var stmt string
 	if c.get("data_type") == "character varying" {
 		maxLength, valid := getMaxLength(c.get("character_maximum_length"))
 		if !valid {
-			fmt.Printf("ALTER TABLE %s.%s ADD COLUMN %s character varying", schema, c.get("table_name"), c.get("column_name"))
+			stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s character varying", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("column_name")))
 		} else {
-			fmt.Printf("ALTER TABLE %s.%s ADD COLUMN %s character varying(%s)", schema, c.get("table_name"), c.get("column_name"), maxLength)
+			stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s character varying(%s)", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("column_name")), maxLength)
 		}
 	} else {
 		dataType := c.get("data_type")
@@ -178,35 +185,40 @@ func (c *ColumnSchema) Add() {
 			dataType = c.get("array_type")+"[]"
 		}
 		//fmt.Printf("ALTER TABLE %s.%s ADD COLUMN %s %s", schema, c.get("table_name"), c.get("column_name"), c.get("data_type"))
-		fmt.Printf("ALTER TABLE %s.%s ADD COLUMN %s %s", schema, c.get("table_name"), c.get("column_name"), dataType)
+		stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("column_name")), dataType)
 	}
 
 	if c.get("is_nullable") == "NO" {
-		fmt.Printf(" NOT NULL")
+		stmt += " NOT NULL"
 	}
 	if c.get("column_default") != "null" {
-		fmt.Printf(" DEFAULT %s", c.get("column_default"))
+		stmt += fmt.Sprintf(" DEFAULT %s", c.get("column_default"))
 	}
 	// NOTE: there are more identity column sequence options according to the PostgreSQL 
 	// CREATE TABLE docs, but these do not appear to be available as of version 10.1
 	if c.get("is_identity") == "YES" {
-		fmt.Printf(" GENERATED %s AS IDENTITY", c.get("identity_generation"))
+		stmt += fmt.Sprintf(" GENERATED %s AS IDENTITY", c.get("identity_generation"))
 	}
-	fmt.Printf(";\n")
+	change.addSql("%s", stmt)
+	return change
 }
 
-// Drop prints SQL to drop the column
-func (c *ColumnSchema) Drop() {
+// Drop returns SQL to drop the column
+func (c *ColumnSchema) Drop() *SchemaChange {
+	change := newChange("COLUMN", ActionDrop, c.get("table_schema"), c.get("table_name")+"."+c.get("column_name"))
+	change.Destructive = true
 	// if dropping column
-	fmt.Printf("ALTER TABLE %s.%s DROP COLUMN IF EXISTS %s;\n", c.get("table_schema"), c.get("table_name"), c.get("column_name"))
+	change.addSql("ALTER TABLE %s DROP COLUMN IF EXISTS %s", qualifiedName(c.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")))
+	return change
 }
 
 // Change handles the case where the table and column match, but the details do not
-func (c *ColumnSchema) Change(obj interface{}) {
+func (c *ColumnSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*ColumnSchema)
 	if !ok {
-		fmt.Println("Error!!!, ColumnSchema.Change(obj) needs a ColumnSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, ColumnSchema.Change(obj) needs a ColumnSchema instance", c2)
 	}
+	change := newChange("COLUMN", ActionChange, c2.get("table_schema"), c.get("table_name")+"."+c.get("column_name"))
 
 	// Adjust data type for array columns
 	dataType1 := c.get("data_type")
@@ -235,49 +247,50 @@ func (c *ColumnSchema) Change(obj interface{}) {
 				max2Int, err2 := strconv.Atoi(max2)
 				check("converting string to int", err2)
 				if max1Int < max2Int {
-					fmt.Println("-- WARNING: The next statement will shorten a character varying column, which may result in data loss.")
+					change.addWarning("WARNING: This will shorten a character varying column, which may result in data loss.")
+					change.Destructive = true
 				}
-				fmt.Printf("-- max1Valid: %v  max2Valid: %v \n", max1Valid, max2Valid)
-				fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s TYPE character varying(%s);\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"), max1)
+				change.addSql("ALTER TABLE %s ALTER COLUMN %s TYPE character varying(%s)", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")), max1)
 			}
 		}
 	}
 
 	// Code and test a column change from integer to bigint
 	if dataType1 != dataType2 {
-		fmt.Printf("-- WARNING: This type change may not work well: (%s to %s).\n", dataType2, dataType1)
+		change.addWarning("WARNING: This type change may not work well: (%s to %s).", dataType2, dataType1)
+		change.Destructive = true
 		if strings.HasPrefix(dataType1, "character") {
 			max1, max1Valid := getMaxLength(c.get("character_maximum_length"))
 			if !max1Valid {
-				fmt.Println("-- WARNING: varchar column has no maximum length.  Setting to 1024")
+				change.addWarning("WARNING: varchar column has no maximum length.  Setting to 1024")
 			}
-			fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s TYPE %s(%s);\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"), dataType1, max1)
+			change.addSql("ALTER TABLE %s ALTER COLUMN %s TYPE %s(%s)", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")), dataType1, max1)
 		} else {
-			fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s TYPE %s;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"), dataType1)
+			change.addSql("ALTER TABLE %s ALTER COLUMN %s TYPE %s", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")), dataType1)
 		}
 	}
 
 	// Detect column default change (or added, dropped)
 	if c.get("column_default") == "null" {
 		if c2.get("column_default") != "null" {
-			fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s DROP DEFAULT;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"))
+			change.addSql("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")))
 		}
 	} else if c.get("column_default") != c2.get("column_default") {
-		fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s SET DEFAULT %s;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"), c.get("column_default"))
+		change.addSql("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")), c.get("column_default"))
 	}
 
 	// Detect identity column change
-	// Save result to variable instead of printing because order for adding/removing
+	// Save result to variable instead of adding it because order for adding/removing
 	// is_nullable affects identity columns
 	var identitySql string
 	if c.get("is_identity") != c2.get("is_identity") {
 		// Knowing the version of db2 would eliminate the need for this warning
-		fmt.Println("-- WARNING: identity columns are not supported in PostgreSQL versions < 10.")
-		fmt.Println("-- Attempting to create identity columns in earlier versions will probably result in errors.")
+		change.addWarning("WARNING: identity columns are not supported in PostgreSQL versions < 10.")
+		change.addWarning("Attempting to create identity columns in earlier versions will probably result in errors.")
 		if c.get("is_identity") == "YES" {
-			identitySql = fmt.Sprintf("ALTER TABLE \"%s\".\"%s\" ALTER COLUMN \"%s\" ADD GENERATED %s AS IDENTITY;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"), c.get("identity_generation"))
+			identitySql = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ADD GENERATED %s AS IDENTITY", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")), c.get("identity_generation"))
 		} else {
-			identitySql = fmt.Sprintf("ALTER TABLE \"%s\".\"%s\" ALTER COLUMN \"%s\" DROP IDENTITY;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"))
+			identitySql = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP IDENTITY", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")))
 		}
 	}
 
@@ -285,71 +298,62 @@ func (c *ColumnSchema) Change(obj interface{}) {
 	if c.get("is_nullable") != c2.get("is_nullable") {
 		if c.get("is_nullable") == "YES" {
 			if identitySql != "" {
-				fmt.Printf(identitySql)
+				change.addSql("%s", identitySql)
 			}
-			fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s DROP NOT NULL;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"))
+			change.addSql("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")))
 		} else {
-			fmt.Printf("ALTER TABLE %s.%s ALTER COLUMN %s SET NOT NULL;\n", c2.get("table_schema"), c.get("table_name"), c.get("column_name"))
+			change.addSql("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", qualifiedName(c2.get("table_schema"), c.get("table_name")), quoteIdent(c.get("column_name")))
 			if identitySql != "" {
-				fmt.Printf(identitySql)
+				change.addSql("%s", identitySql)
 			}
 		}
 	} else {
 		if identitySql != "" {
-			fmt.Printf(identitySql)
+			change.addSql("%s", identitySql)
 		}
 	}
+	return change
 }
 
 // ==================================
 // Standalone Functions
 // ==================================
 
-// compare outputs SQL to make the columns match between two databases or schemas
-func compare(conn1 *sql.DB, conn2 *sql.DB, tpl *template.Template) {
-	buf1 := new(bytes.Buffer)
-	tpl.Execute(buf1, dbInfo1)
-
-	buf2 := new(bytes.Buffer)
-	tpl.Execute(buf2, dbInfo2)
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	//rows1 := make([]map[string]string, 500)
-	rows1 := make(ColumnRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+// compare returns the changes needed to make the columns match between two databases or schemas
+func compare(cat1 Catalog, cat2 Catalog, name string, tpl *template.Template) []*SchemaChange {
+	rows1 := ColumnRows(cat1.Query(name, tpl))
 	sort.Sort(rows1)
 
-	//rows2 := make([]map[string]string, 500)
-	rows2 := make(ColumnRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
-	sort.Sort(&rows2)
+	rows2 := ColumnRows(cat2.Query(name, tpl))
+	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here for some unknown reason
 	var schema1 Schema = &ColumnSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &ColumnSchema{rows: rows2, rowNum: -1}
 
-	// Compare the columns
-	doDiff(schema1, schema2)
-
+	// Compare the columns, leaving out the columns of new tables (see addedTables)
+	added := addedTables(cat1, cat2)
+	var changes []*SchemaChange
+	for _, change := range doDiff(schema1, schema2) {
+		if change.Action == ActionAdd && added[change.DB1["table_schema"]+"."+change.DB1["table_name"]] {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
 }
 
-// compareColumns outputs SQL to make the columns match between two databases or schemas
-func compareColumns(conn1 *sql.DB, conn2 *sql.DB) {
+// compareColumns returns the changes needed to make the columns match between two databases or schemas
+func compareColumns(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-    compare(conn1, conn2, columnSqlTemplate)
+    return compare(cat1, cat2, "COLUMN", columnSqlTemplate)
 
 }
 
-// compareColumns outputs SQL to make the tables columns (without views columns) match between two databases or schemas
-func compareTableColumns(conn1 *sql.DB, conn2 *sql.DB) {
+// compareTableColumns returns the changes needed to make the tables columns (without views columns) match between two databases or schemas
+func compareTableColumns(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-    compare(conn1, conn2, tableColumnSqlTemplate)
+    return compare(cat1, cat2, "TABLE_COLUMN", tableColumnSqlTemplate)
 
 }
 
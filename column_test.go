@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ColumnSchemaAdd(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	rows := ColumnRows{
+		{"table_schema": "s1", "table_name": "t1", "column_name": "name", "data_type": "character varying",
+			"character_maximum_length": "40", "is_nullable": "NO", "column_default": "null", "is_identity": "NO"},
+	}
+	c := &ColumnSchema{rows: rows, rowNum: 0}
+
+	change := c.Add()
+	if change.Kind != "COLUMN" || change.Action != ActionAdd || change.Name != "s1.t1.name" {
+		t.Errorf("Wrong change identity: %s %s %s", change.Kind, change.Action, change.Name)
+	}
+	expected := "ALTER TABLE s1.t1 ADD COLUMN name character varying(40) NOT NULL"
+	if len(change.Statements) != 1 || change.Statements[0] != expected {
+		t.Errorf("Wrong statements: %q instead of %q", change.Statements, expected)
+	}
+	if change.Destructive {
+		t.Error("Adding a column should not be destructive")
+	}
+}
+
+func Test_ColumnSchemaChange(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row1 := map[string]string{"table_schema": "s1", "table_name": "t1", "column_name": "name", "data_type": "character varying",
+		"character_maximum_length": "20", "is_nullable": "YES", "column_default": "null", "is_identity": "NO"}
+	row2 := map[string]string{"table_schema": "s1", "table_name": "t1", "column_name": "name", "data_type": "character varying",
+		"character_maximum_length": "40", "is_nullable": "YES", "column_default": "null", "is_identity": "NO"}
+	c1 := &ColumnSchema{rows: ColumnRows{row1}, rowNum: 0}
+	c2 := &ColumnSchema{rows: ColumnRows{row2}, rowNum: 0}
+
+	change := c1.Change(c2)
+	expected := "ALTER TABLE s1.t1 ALTER COLUMN name TYPE character varying(20)"
+	if len(change.Statements) != 1 || change.Statements[0] != expected {
+		t.Errorf("Wrong statements: %q instead of %q", change.Statements, expected)
+	}
+	if !change.Destructive || len(change.Warnings) != 1 {
+		t.Errorf("Shortening a column should be destructive with a warning: %v %q", change.Destructive, change.Warnings)
+	}
+
+	// Nothing to do when the columns match
+	if change = c2.Change(c2); !change.isEmpty() {
+		t.Errorf("Expected no change for matching columns: %q", change.Statements)
+	}
+}
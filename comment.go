@@ -0,0 +1,299 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/template"
+
+	"github.com/joncrlsn/misc"
+)
+
+var (
+	commentSqlTemplate = initCommentSqlTemplate()
+)
+
+// Initializes the Sql template.  There is a row for every object that can have a
+// comment, with a null description when it has none, so a comment is only removed
+// from an object that is in both databases.
+func initCommentSqlTemplate() *template.Template {
+	sql := `
+WITH objects (object_type, schema_name, table_name, object_name, arguments, classoid, objoid, objsubid) AS (
+    SELECT 'SCHEMA', n.nspname, NULL, NULL, NULL, 'pg_namespace'::regclass, n.oid, 0
+    FROM pg_catalog.pg_namespace AS n
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    UNION ALL
+    SELECT 'EXTENSION', NULL, NULL, e.extname, NULL, 'pg_extension'::regclass, e.oid, 0
+    FROM pg_catalog.pg_extension AS e
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = e.extnamespace)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    UNION ALL
+    SELECT 'ROLE', NULL, NULL, r.rolname, NULL, 'pg_authid'::regclass, r.oid, 0
+    FROM pg_catalog.pg_roles AS r
+    WHERE r.rolname !~ '^pg_'
+    UNION ALL
+    SELECT CASE c.relkind WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'S' THEN 'SEQUENCE'
+           WHEN 'i' THEN 'INDEX' WHEN 'I' THEN 'INDEX' ELSE 'TABLE' END
+        , n.nspname, NULL, c.relname, NULL, 'pg_class'::regclass, c.oid, 0
+    FROM pg_catalog.pg_class AS c
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    LEFT OUTER JOIN pg_catalog.pg_index AS x ON (x.indexrelid = c.oid)
+    WHERE c.relkind IN ('r', 'p', 'v', 'm', 'S', 'i', 'I')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "COALESCE(x.indrelid, c.oid)"}}
+    UNION ALL
+    -- The columns of tables, views, and composite types
+    SELECT 'COLUMN', n.nspname, c.relname, a.attname, NULL, 'pg_class'::regclass, c.oid, a.attnum
+    FROM pg_catalog.pg_attribute AS a
+    INNER JOIN pg_catalog.pg_class AS c ON (c.oid = a.attrelid)
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE c.relkind IN ('r', 'p', 'v', 'm', 'c')
+    AND a.attnum > 0
+    AND NOT a.attisdropped
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+    AND {{$.ExtensionFilter "pg_type" "c.reltype"}}
+    UNION ALL
+    SELECT 'CONSTRAINT', n.nspname, c.relname, con.conname, NULL, 'pg_constraint'::regclass, con.oid, 0
+    FROM pg_catalog.pg_constraint AS con
+    INNER JOIN pg_catalog.pg_class AS c ON (c.oid = con.conrelid)
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+    UNION ALL
+    SELECT 'DOMAIN CONSTRAINT', n.nspname, t.typname, con.conname, NULL, 'pg_constraint'::regclass, con.oid, 0
+    FROM pg_catalog.pg_constraint AS con
+    INNER JOIN pg_catalog.pg_type AS t ON (t.oid = con.contypid)
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_type" "t.oid"}}
+    UNION ALL
+    SELECT CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END
+        , n.nspname, NULL, t.typname, NULL, 'pg_type'::regclass, t.oid, 0
+    FROM pg_catalog.pg_type AS t
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+    LEFT OUTER JOIN pg_catalog.pg_class AS c ON (c.oid = t.typrelid)
+    WHERE t.typtype IN ('e', 'c', 'd', 'r')
+    AND (t.typtype <> 'c' OR c.relkind = 'c')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_type" "t.oid"}}
+    UNION ALL
+    SELECT CASE p.prokind WHEN 'a' THEN 'AGGREGATE' WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END
+        , n.nspname, NULL, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid), 'pg_proc'::regclass, p.oid, 0
+    FROM pg_catalog.pg_proc AS p
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = p.pronamespace)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_proc" "p.oid"}}
+    UNION ALL
+    SELECT 'TRIGGER', n.nspname, c.relname, t.tgname, NULL, 'pg_trigger'::regclass, t.oid, 0
+    FROM pg_catalog.pg_trigger AS t
+    INNER JOIN pg_catalog.pg_class AS c ON (c.oid = t.tgrelid)
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE NOT t.tgisinternal
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+    UNION ALL
+    SELECT 'POLICY', n.nspname, c.relname, p.polname, NULL, 'pg_policy'::regclass, p.oid, 0
+    FROM pg_catalog.pg_policy AS p
+    INNER JOIN pg_catalog.pg_class AS c ON (c.oid = p.polrelid)
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+)
+SELECT o.object_type || ':' || concat_ws('.', {{if eq $.DbSchema "*" }}o.schema_name, {{end}}o.table_name, o.object_name)
+        || COALESCE('(' || o.arguments || ')', '') AS compare_name
+    , o.object_type
+    , o.schema_name
+    , o.table_name
+    , o.object_name
+    , o.arguments
+    , COALESCE(d.description, sd.description) AS description
+FROM objects AS o
+LEFT OUTER JOIN pg_catalog.pg_description AS d ON (d.classoid = o.classoid AND d.objoid = o.objoid AND d.objsubid = o.objsubid)
+LEFT OUTER JOIN pg_catalog.pg_shdescription AS sd ON (sd.classoid = o.classoid AND sd.objoid = o.objoid)
+`
+	t := template.New("CommentSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// CommentRows definition
+// ==================================
+
+// CommentRows is a sortable slice of string maps
+type CommentRows []map[string]string
+
+func (slice CommentRows) Len() int {
+	return len(slice)
+}
+
+func (slice CommentRows) Less(i, j int) bool {
+	return slice[i]["compare_name"] < slice[j]["compare_name"]
+}
+
+func (slice CommentRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// CommentSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// CommentSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type CommentSchema struct {
+	rows   CommentRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *CommentSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *CommentSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *CommentSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*CommentSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a CommentSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	return val
+}
+
+// ObjectKey identifies the comment in the current row when ordering by dependency
+func (c *CommentSchema) ObjectKey() string {
+	return "comment:" + c.get("compare_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *CommentSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// schema returns the schema of the object in db2, or "" for an object that isn't
+// in a schema (a role or an extension)
+func (c *CommentSchema) schema() string {
+	if c.get("schema_name") == "null" {
+		return ""
+	}
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		return dbInfo2.DbSchema
+	}
+	return c.get("schema_name")
+}
+
+// target returns the object as it is written after COMMENT ON, in the given schema
+func (c *CommentSchema) target(schema string) string {
+	name := quoteIdent(c.get("object_name"))
+	switch c.get("object_type") {
+	case "SCHEMA":
+		return "SCHEMA " + quoteIdent(schema)
+	case "ROLE", "EXTENSION":
+		return c.get("object_type") + " " + name
+	case "COLUMN":
+		return "COLUMN " + qualifiedName(schema, c.get("table_name")) + "." + name
+	case "CONSTRAINT", "TRIGGER", "POLICY":
+		return c.get("object_type") + " " + name + " ON " + qualifiedName(schema, c.get("table_name"))
+	case "DOMAIN CONSTRAINT":
+		return "CONSTRAINT " + name + " ON DOMAIN " + qualifiedName(schema, c.get("table_name"))
+	case "FUNCTION", "PROCEDURE", "AGGREGATE":
+		return c.get("object_type") + " " + qualifiedName(schema, c.get("object_name")) + "(" + c.get("arguments") + ")"
+	}
+	return c.get("object_type") + " " + qualifiedName(schema, c.get("object_name"))
+}
+
+// name returns the name of the object for the change
+func (c *CommentSchema) name() string {
+	name := c.get("object_name")
+	if c.get("table_name") != "null" {
+		name = c.get("table_name") + "." + name
+	}
+	if c.get("arguments") != "null" {
+		name += "(" + c.get("arguments") + ")"
+	}
+	return name
+}
+
+// addComment adds the SQL to set the comment of the object in the given schema to
+// the description, or to remove it when the description is null
+func (c *CommentSchema) addComment(change *SchemaChange, schema string, description string) {
+	if description == "null" {
+		change.addSql("COMMENT ON %s IS NULL", c.target(schema))
+	} else {
+		change.addSql("COMMENT ON %s IS %s", c.target(schema), quoteLiteral(description))
+	}
+}
+
+// Add returns SQL to comment on a new object
+func (c *CommentSchema) Add() *SchemaChange {
+	schema := c.schema()
+	change := newChange("COMMENT", ActionAdd, schema, c.name())
+	if c.get("description") != "null" {
+		c.addComment(change, schema, c.get("description"))
+	}
+	return change
+}
+
+// Drop returns nothing because the comment of an object goes with the object
+func (c *CommentSchema) Drop() *SchemaChange {
+	return nil
+}
+
+// Change sets or removes the comment when it is different
+func (c *CommentSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*CommentSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, CommentSchema.Change(obj) needs a CommentSchema instance", c2)
+	}
+	schema := c2.schema()
+	change := newChange("COMMENT", ActionChange, schema, c.name())
+	if c.get("description") != c2.get("description") {
+		c.addComment(change, schema, c.get("description"))
+	}
+	return change
+}
+
+// compareComments returns the changes needed to make the comments match between two
+// databases or schemas
+func compareComments(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := CommentRows(cat1.Query("COMMENT", commentSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := CommentRows(cat2.Query("COMMENT", commentSqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here
+	var schema1 Schema = &CommentSchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &CommentSchema{rows: rows2, rowNum: -1}
+
+	// Compare the comments
+	return doDiff(schema1, schema2)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_CommentSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row := func(objectType, schema, table, name, args, description string) map[string]string {
+		return map[string]string{"object_type": objectType, "schema_name": schema, "table_name": table,
+			"object_name": name, "arguments": args, "description": description}
+	}
+	tests := []struct {
+		row      map[string]string
+		expected string
+	}{
+		{row("SCHEMA", "app", "null", "null", "null", "Application data"), `COMMENT ON SCHEMA app IS 'Application data'`},
+		{row("ROLE", "null", "null", "Report Reader", "null", "Read only"), `COMMENT ON ROLE "Report Reader" IS 'Read only'`},
+		{row("TABLE", "app", "null", "account", "null", "A customer's account"), `COMMENT ON TABLE app.account IS 'A customer''s account'`},
+		{row("MATERIALIZED VIEW", "app", "null", "totals", "null", `C:\reports`), `COMMENT ON MATERIALIZED VIEW app.totals IS E'C:\\reports'`},
+		{row("COLUMN", "app", "account", "order", "null", "Sort order"), `COMMENT ON COLUMN app.account."order" IS 'Sort order'`},
+		{row("CONSTRAINT", "app", "account", "account_pkey", "null", "PK"), `COMMENT ON CONSTRAINT account_pkey ON app.account IS 'PK'`},
+		{row("DOMAIN CONSTRAINT", "app", "email", "email_check", "null", "Has an @"), `COMMENT ON CONSTRAINT email_check ON DOMAIN app.email IS 'Has an @'`},
+		{row("FUNCTION", "app", "null", "total", "a integer, b integer", "Adds"), `COMMENT ON FUNCTION app.total(a integer, b integer) IS 'Adds'`},
+		{row("TRIGGER", "app", "account", "audit", "null", "Audits"), `COMMENT ON TRIGGER audit ON app.account IS 'Audits'`},
+	}
+	for _, test := range tests {
+		c := &CommentSchema{rows: CommentRows{test.row}, rowNum: 0}
+		expectStatements(t, c.Add(), test.expected)
+	}
+
+	// A new object without a comment, or a dropped object, needs nothing
+	c1 := &CommentSchema{rows: CommentRows{row("TABLE", "app", "null", "account", "null", "null")}, rowNum: 0}
+	if change := c1.Add(); !change.isEmpty() {
+		t.Errorf("Expected no statements: %q", change.Statements)
+	}
+	if c1.Drop() != nil {
+		t.Errorf("Dropped objects should have no comment change")
+	}
+
+	// A removed comment is set to NULL
+	c2 := &CommentSchema{rows: CommentRows{row("TABLE", "app", "null", "account", "null", "Old")}, rowNum: 0}
+	expectStatements(t, c1.Change(c2), `COMMENT ON TABLE app.account IS NULL`)
+	expectStatements(t, c2.Change(c1), `COMMENT ON TABLE app.account IS 'Old'`)
+
+	// The comment goes on the object in the db2 schema
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "app", "app_test"
+	expectStatements(t, c2.Add(), `COMMENT ON TABLE app_test.account IS 'Old'`)
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+}
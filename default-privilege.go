@@ -0,0 +1,249 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"github.com/joncrlsn/misc"
+	"os"
+	"sort"
+	"text/template"
+)
+
+var (
+	defaultPrivilegeSqlTemplate = initDefaultPrivilegeSqlTemplate()
+)
+
+// Initializes the Sql template.  There is one row for each role that is granted
+// privileges in an entry of pg_default_acl.  A role that has no entry for all schemas
+// (IN SCHEMA is left out) has the built-in default privileges, which are in
+// default_acl so that a missing entry can be compared with one that revokes them.
+// An entry for one schema only adds to those, so it has no default_acl.
+func initDefaultPrivilegeSqlTemplate() *template.Template {
+	sql := `
+WITH entries AS (
+    SELECT d.oid
+        , a.rolname AS role_name
+        , n.nspname AS schema_name
+        , d.defaclobjtype AS object_type
+        , d.defaclacl AS acl
+        , CASE WHEN d.defaclnamespace = 0
+          THEN pg_catalog.acldefault(d.defaclobjtype, d.defaclrole) END AS default_acl
+    FROM pg_catalog.pg_default_acl AS d
+    INNER JOIN pg_catalog.pg_roles AS a ON (a.oid = d.defaclrole)
+    LEFT OUTER JOIN pg_catalog.pg_namespace AS n ON (n.oid = d.defaclnamespace)
+    WHERE d.defaclobjtype IN ('r', 'S', 'f', 'T', 'n')
+    AND (d.defaclnamespace = 0 OR ({{$.SchemaFilter "n.nspname"}}))
+), granted AS (
+    SELECT e.oid, g.acl
+        , (SELECT x.grantee FROM pg_catalog.aclexplode(ARRAY[g.acl]) AS x LIMIT 1) AS grantee
+    FROM entries AS e, unnest(e.acl) AS g(acl)
+), defaults AS (
+    SELECT e.oid, g.acl
+        , (SELECT x.grantee FROM pg_catalog.aclexplode(ARRAY[g.acl]) AS x LIMIT 1) AS grantee
+    FROM entries AS e, unnest(e.default_acl) AS g(acl)
+)
+SELECT concat_ws(':', e.role_name, e.object_type
+        , {{if eq $.DbSchema "*" }}e.schema_name{{else}}CASE WHEN e.schema_name IS NOT NULL THEN 'IN SCHEMA' END{{end}}) AS compare_name
+    , e.role_name
+    , e.schema_name
+    , CASE e.object_type
+      WHEN 'r' THEN 'TABLES'
+      WHEN 'S' THEN 'SEQUENCES'
+      WHEN 'f' THEN 'FUNCTIONS'
+      WHEN 'T' THEN 'TYPES'
+      WHEN 'n' THEN 'SCHEMAS'
+      END AS object_type
+    , g.acl AS default_privilege_acl
+    , d.acl AS default_acl
+FROM granted AS g
+FULL OUTER JOIN defaults AS d ON (d.oid = g.oid AND d.grantee = g.grantee)
+INNER JOIN entries AS e ON (e.oid = COALESCE(g.oid, d.oid));
+`
+
+	t := template.New("DefaultPrivilegeSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// DefaultPrivilegeRows definition
+// ==================================
+
+// DefaultPrivilegeRows is a sortable slice of string maps
+type DefaultPrivilegeRows []map[string]string
+
+func (slice DefaultPrivilegeRows) Len() int {
+	return len(slice)
+}
+
+func (slice DefaultPrivilegeRows) Less(i, j int) bool {
+	if slice[i]["compare_name"] != slice[j]["compare_name"] {
+		return slice[i]["compare_name"] < slice[j]["compare_name"]
+	}
+	return defaultPrivilegeGrantee(slice[i]) < defaultPrivilegeGrantee(slice[j])
+}
+
+func (slice DefaultPrivilegeRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// defaultPrivilegeGrantee returns the role that is granted privileges in the row
+func defaultPrivilegeGrantee(row map[string]string) string {
+	role, _ := parseAcl(row["default_privilege_acl"])
+	if len(role) == 0 {
+		role, _ = parseAcl(row["default_acl"])
+	}
+	return role
+}
+
+// ==================================
+// DefaultPrivilegeSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// DefaultPrivilegeSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type DefaultPrivilegeSchema struct {
+	rows   DefaultPrivilegeRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *DefaultPrivilegeSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *DefaultPrivilegeSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *DefaultPrivilegeSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*DefaultPrivilegeSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a DefaultPrivilegeSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	if val != 0 {
+		return val
+	}
+	return misc.CompareStrings(defaultPrivilegeGrantee(c.Row()), defaultPrivilegeGrantee(c2.Row()))
+}
+
+// ObjectKey identifies the schema of the entry in the current row, or its role when
+// it is for all schemas, when ordering by dependency
+func (c *DefaultPrivilegeSchema) ObjectKey() string {
+	if c.get("schema_name") == "null" {
+		return "role:" + c.get("role_name")
+	}
+	return "schema:" + c.get("schema_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *DefaultPrivilegeSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// schema returns the schema of the entry in db2, or an empty string when the entry
+// is for all schemas
+func (c *DefaultPrivilegeSchema) schema() string {
+	if c.get("schema_name") == "null" {
+		return ""
+	}
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		return dbInfo2.DbSchema
+	}
+	return c.get("schema_name")
+}
+
+// addGrants adds the SQL that changes the default privileges of the row's grantee in
+// the given schema from the have privileges to the want privileges
+func (c *DefaultPrivilegeSchema) addGrants(change *SchemaChange, schema string, want aclGrants, have aclGrants) {
+	alter := "ALTER DEFAULT PRIVILEGES FOR ROLE " + quoteIdent(c.get("role_name"))
+	if len(schema) > 0 {
+		alter += " IN SCHEMA " + quoteIdent(schema)
+	}
+	grantee := quoteIdent(defaultPrivilegeGrantee(c.Row()))
+	addGrantSql(change, alter+" ", c.get("object_type"), grantee, want, have)
+}
+
+// Add returns SQL to give db2 the default privileges of db1, where db2 has the
+// built-in ones (or none, for a schema)
+func (c *DefaultPrivilegeSchema) Add() *SchemaChange {
+	schema := c.schema()
+	change := newChange("DEFAULT_PRIVILEGE", ActionAdd, schema, c.get("role_name")+"."+c.get("object_type"))
+
+	_, want := parseAclGrants(c.get("default_privilege_acl"))
+	_, have := parseAclGrants(c.get("default_acl"))
+	c.addGrants(change, schema, want, have)
+	return change
+}
+
+// Drop returns SQL to put the default privileges in db2 back to the built-in ones
+// (or none, for a schema)
+func (c *DefaultPrivilegeSchema) Drop() *SchemaChange {
+	schema := c.schema()
+	change := newChange("DEFAULT_PRIVILEGE", ActionDrop, schema, c.get("role_name")+"."+c.get("object_type"))
+
+	_, want := parseAclGrants(c.get("default_acl"))
+	_, have := parseAclGrants(c.get("default_privilege_acl"))
+	c.addGrants(change, schema, want, have)
+	return change
+}
+
+// Change handles the case where the role, schema, object type, and grantee match,
+// but the privileges do not
+func (c *DefaultPrivilegeSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*DefaultPrivilegeSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a DefaultPrivilegeSchema instance", c2)
+	}
+
+	schema := c2.schema()
+	change := newChange("DEFAULT_PRIVILEGE", ActionChange, schema, c.get("role_name")+"."+c.get("object_type"))
+
+	_, want := parseAclGrants(c.get("default_privilege_acl"))
+	_, have := parseAclGrants(c2.get("default_privilege_acl"))
+	c.addGrants(change, schema, want, have)
+	return change
+}
+
+// ==================================
+// Functions
+// ==================================
+
+// compareDefaultPrivileges returns the changes needed to make the default privileges
+// (ALTER DEFAULT PRIVILEGES) match between DBs or schemas
+func compareDefaultPrivileges(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := DefaultPrivilegeRows(cat1.Query("DEFAULT_PRIVILEGE", defaultPrivilegeSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := DefaultPrivilegeRows(cat2.Query("DEFAULT_PRIVILEGE", defaultPrivilegeSqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here for some unknown reason
+	var schema1 Schema = &DefaultPrivilegeSchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &DefaultPrivilegeSchema{rows: rows2, rowNum: -1}
+
+	return doDiff(schema1, schema2)
+}
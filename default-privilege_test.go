@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_DefaultPrivilegeSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row := func(schema, objectType, acl, defaultAcl string) map[string]string {
+		return map[string]string{"compare_name": "migrator:" + objectType + ":" + schema, "role_name": "migrator", "schema_name": schema,
+			"object_type": objectType, "default_privilege_acl": acl, "default_acl": defaultAcl}
+	}
+
+	// A schema entry that db2 does not have
+	c := &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("s1", "TABLES", "reporting=r/migrator", "null")}, rowNum: 0}
+	change := c.Add()
+	expectStatements(t, change, "ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 GRANT SELECT ON TABLES TO reporting")
+	if change.Name != "s1.migrator.TABLES" || c.ObjectKey() != "schema:s1" {
+		t.Errorf("Wrong name or key: %s %s", change.Name, c.ObjectKey())
+	}
+	expectStatements(t, c.Drop(), "ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 REVOKE SELECT ON TABLES FROM reporting")
+
+	// PUBLIC's EXECUTE was revoked in db1, and db2 has the built-in default privileges
+	c = &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("null", "FUNCTIONS", "null", "=X/migrator")}, rowNum: 0}
+	change = c.Add()
+	expectStatements(t, change, "ALTER DEFAULT PRIVILEGES FOR ROLE migrator REVOKE EXECUTE ON FUNCTIONS FROM public")
+	if change.Name != "migrator.FUNCTIONS" || c.ObjectKey() != "role:migrator" {
+		t.Errorf("Wrong name or key: %s %s", change.Name, c.ObjectKey())
+	}
+	expectStatements(t, c.Drop(), "ALTER DEFAULT PRIVILEGES FOR ROLE migrator GRANT EXECUTE ON FUNCTIONS TO public")
+
+	// The owner's own privileges match the built-in ones, so there is nothing to do
+	c = &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("null", "FUNCTIONS", "migrator=X/migrator", "migrator=X/migrator")}, rowNum: 0}
+	if change = c.Add(); !change.isEmpty() {
+		t.Errorf("Unexpected statements: %q", change.Statements)
+	}
+
+	c1 := &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("s1", "TABLES", "reporting=r/migrator", "null")}, rowNum: 0}
+	c2 := &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("s1", "TABLES", "reporting=arw/migrator", "null")}, rowNum: 0}
+	expectStatements(t, c1.Change(c2), "ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 REVOKE INSERT, UPDATE ON TABLES FROM reporting")
+
+	// Privileges with a grant option (*)
+	c1 = &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("s1", "TABLES", "reporting=r*w/migrator", "null")}, rowNum: 0}
+	expectStatements(t, c1.Add(),
+		"ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 GRANT UPDATE ON TABLES TO reporting",
+		"ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 GRANT SELECT ON TABLES TO reporting WITH GRANT OPTION")
+	expectStatements(t, c1.Drop(), "ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 REVOKE SELECT, UPDATE ON TABLES FROM reporting")
+	c2 = &DefaultPrivilegeSchema{rows: DefaultPrivilegeRows{row("s1", "TABLES", "reporting=r*w*/migrator", "null")}, rowNum: 0}
+	expectStatements(t, c1.Change(c2), "ALTER DEFAULT PRIVILEGES FOR ROLE migrator IN SCHEMA s1 REVOKE GRANT OPTION FOR UPDATE ON TABLES FROM reporting")
+}
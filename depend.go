@@ -0,0 +1,348 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// depend.go orders the SQL generated for schema type ALL using the dependencies
+// recorded in pg_depend, so that objects are created after the objects they need
+// and dropped before the objects they need.
+//
+
+package main
+
+import (
+	"container/heap"
+	"strings"
+	"text/template"
+)
+
+// Every catalog object we generate SQL for is identified by an object key made of a
+// kind and the schema-qualified name of the object, e.g. "table:public.account",
+// "column:public.account.id", or "index:public.account_pkey".  The dependency query
+// below builds the same keys on the database side that ObjectKey builds in Go.
+var (
+	dependencySqlTemplate = initDependencySqlTemplate()
+)
+
+// Initializes the Sql template
+func initDependencySqlTemplate() *template.Template {
+	sql := `
+WITH extension_members AS (
+    SELECT d.classid, d.objid, e.extname
+    FROM pg_catalog.pg_depend AS d
+    INNER JOIN pg_catalog.pg_extension AS e ON (e.oid = d.refobjid)
+    WHERE d.refclassid = 'pg_extension'::regclass
+    AND d.deptype = 'e'
+), relations AS (
+    SELECT c.oid
+        , CASE c.relkind
+          WHEN 'v' THEN 'view'
+          WHEN 'm' THEN 'matview'
+          WHEN 'S' THEN 'sequence'
+          WHEN 'i' THEN 'index'
+          WHEN 'I' THEN 'index'
+          WHEN 'c' THEN 'type'
+          ELSE 'table' END || ':' || n.nspname || '.' || c.relname AS object_key
+        , n.nspname || '.' || c.relname AS relation_name
+    FROM pg_catalog.pg_class AS c
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE n.nspname NOT LIKE 'pg_%'
+    AND n.nspname <> 'information_schema'
+    AND NOT EXISTS (SELECT 1 FROM extension_members AS m WHERE m.classid = 'pg_class'::regclass AND m.objid = c.oid)
+), objects AS (
+    SELECT 'pg_class'::regclass::oid AS classid, r.oid AS objid, 0 AS objsubid, r.object_key
+    FROM relations AS r
+    UNION ALL
+    SELECT 'pg_class'::regclass::oid, a.attrelid, a.attnum, 'column:' || r.relation_name || '.' || a.attname
+    FROM pg_catalog.pg_attribute AS a
+    INNER JOIN relations AS r ON (r.oid = a.attrelid)
+    WHERE a.attnum > 0
+    AND NOT a.attisdropped
+    UNION ALL
+    -- A column default is keyed as the column it belongs to
+    SELECT 'pg_attrdef'::regclass::oid, d.oid, 0, 'column:' || r.relation_name || '.' || a.attname
+    FROM pg_catalog.pg_attrdef AS d
+    INNER JOIN pg_catalog.pg_attribute AS a ON (a.attrelid = d.adrelid AND a.attnum = d.adnum)
+    INNER JOIN relations AS r ON (r.oid = d.adrelid)
+    UNION ALL
+    -- A rewrite rule is keyed as the view (or materialized view) it implements
+    SELECT 'pg_rewrite'::regclass::oid, w.oid, 0, r.object_key
+    FROM pg_catalog.pg_rewrite AS w
+    INNER JOIN relations AS r ON (r.oid = w.ev_class)
+    UNION ALL
+    -- Primary key, unique, and exclusion constraints are keyed as the index they use.
+    -- Foreign keys and check constraints have keys of their own.
+    SELECT 'pg_constraint'::regclass::oid, con.oid, 0
+        , CASE con.contype WHEN 'f' THEN 'foreign_key:' || r.relation_name || '.' || con.conname
+          WHEN 'c' THEN 'check_constraint:' || r.relation_name || '.' || con.conname
+          ELSE i.object_key END
+    FROM pg_catalog.pg_constraint AS con
+    INNER JOIN relations AS r ON (r.oid = con.conrelid)
+    LEFT OUTER JOIN relations AS i ON (i.oid = con.conindid)
+    WHERE con.contype IN ('f', 'c', 'p', 'u', 'x')
+    UNION ALL
+    SELECT 'pg_proc'::regclass::oid, p.oid, 0, 'function:' || n.nspname || '.' || p.proname
+        || '(' || pg_catalog.oidvectortypes(p.proargtypes) || ')'
+    FROM pg_catalog.pg_proc AS p
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = p.pronamespace)
+    WHERE n.nspname NOT LIKE 'pg_%'
+    AND n.nspname <> 'information_schema'
+    AND NOT EXISTS (SELECT 1 FROM extension_members AS m WHERE m.classid = 'pg_proc'::regclass AND m.objid = p.oid)
+    UNION ALL
+    SELECT 'pg_trigger'::regclass::oid, t.oid, 0, 'trigger:' || r.relation_name || '.' || t.tgname
+    FROM pg_catalog.pg_trigger AS t
+    INNER JOIN relations AS r ON (r.oid = t.tgrelid)
+    WHERE NOT t.tgisinternal
+    UNION ALL
+    SELECT 'pg_policy'::regclass::oid, p.oid, 0, 'policy:' || r.relation_name || '.' || p.polname
+    FROM pg_catalog.pg_policy AS p
+    INNER JOIN relations AS r ON (r.oid = p.polrelid)
+    UNION ALL
+    -- A user-defined type, with its array type keyed as the type
+    SELECT 'pg_type'::regclass::oid, u.oid, 0, 'type:' || n.nspname || '.' || t.typname
+    FROM pg_catalog.pg_type AS t
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+    INNER JOIN pg_catalog.pg_type AS u ON (u.oid = t.oid OR u.oid = t.typarray)
+    LEFT OUTER JOIN pg_catalog.pg_class AS c ON (c.oid = t.typrelid)
+    WHERE t.typtype IN ('e', 'c', 'd', 'r')
+    AND (t.typtype <> 'c' OR c.relkind = 'c')
+    AND n.nspname NOT LIKE 'pg_%'
+    AND n.nspname <> 'information_schema'
+    AND NOT EXISTS (SELECT 1 FROM extension_members AS m WHERE m.classid = 'pg_type'::regclass AND m.objid = t.oid)
+    UNION ALL
+    SELECT 'pg_extension'::regclass::oid, e.oid, 0, 'extension:' || e.extname
+    FROM pg_catalog.pg_extension AS e
+    UNION ALL
+    -- An object that belongs to an extension (like a PostGIS type or function) is
+    -- keyed as the extension, which creates it
+    SELECT m.classid, m.objid, 0, 'extension:' || m.extname
+    FROM extension_members AS m
+    UNION ALL
+    SELECT 'pg_namespace'::regclass::oid, n.oid, 0, 'schema:' || n.nspname
+    FROM pg_catalog.pg_namespace AS n
+    WHERE n.nspname NOT LIKE 'pg_%'
+    AND n.nspname <> 'information_schema'
+)
+SELECT DISTINCT o1.object_key AS dependent
+    , o2.object_key AS referenced
+FROM pg_catalog.pg_depend AS d
+INNER JOIN objects AS o1 ON (o1.classid = d.classid AND o1.objid = d.objid AND o1.objsubid = d.objsubid)
+INNER JOIN objects AS o2 ON (o2.classid = d.refclassid AND o2.objid = d.refobjid AND o2.objsubid = d.refobjsubid)
+WHERE d.deptype IN ('n', 'a')
+AND o1.object_key <> o2.object_key
+-- A sequence OWNED BY a column is auto-dependent on that column while the column
+-- default depends on the sequence.  Leave out the first edge so they don't form a cycle.
+AND NOT (d.deptype = 'a' AND o1.object_key LIKE 'sequence:%')
+UNION
+-- A new table (or composite type) is created with its columns, column defaults, and
+-- check constraints, so it depends on whatever they depend on (types, sequences,
+-- functions, etc)
+SELECT r.object_key
+    , o2.object_key
+FROM pg_catalog.pg_depend AS d
+INNER JOIN (
+    SELECT 'pg_class'::regclass::oid AS classid, oid AS objid, oid AS relid
+    FROM pg_catalog.pg_class
+    UNION ALL
+    SELECT 'pg_attrdef'::regclass::oid, oid, adrelid
+    FROM pg_catalog.pg_attrdef
+    UNION ALL
+    SELECT 'pg_constraint'::regclass::oid, oid, conrelid
+    FROM pg_catalog.pg_constraint
+    WHERE contype = 'c'
+) AS x ON (x.classid = d.classid AND x.objid = d.objid)
+INNER JOIN relations AS r ON (r.oid = x.relid)
+INNER JOIN objects AS o2 ON (o2.classid = d.refclassid AND o2.objid = d.refobjid AND o2.objsubid = d.refobjsubid)
+WHERE d.deptype = 'n'
+AND (r.object_key LIKE 'table:%' OR r.object_key LIKE 'type:%')
+AND o2.object_key NOT LIKE 'column:%'
+AND o2.object_key <> r.object_key
+UNION
+-- pg_depend does not record that a column depends on its table (or view)
+SELECT 'column:' || r.relation_name || '.' || a.attname
+    , r.object_key
+FROM pg_catalog.pg_attribute AS a
+INNER JOIN relations AS r ON (r.oid = a.attrelid)
+WHERE a.attnum > 0
+AND NOT a.attisdropped;
+`
+	t := template.New("DependencySqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// Dependency graph
+// ==================================
+
+// dependencyGraph maps each object key to the keys of the objects it depends on
+type dependencyGraph map[string][]string
+
+// loadDependencies reads the dependencies between the user objects of a database
+func loadDependencies(cat Catalog) dependencyGraph {
+	graph := make(dependencyGraph)
+	for _, row := range cat.Query("DEPENDENCY", dependencySqlTemplate) {
+		graph[row["dependent"]] = append(graph[row["dependent"]], row["referenced"])
+	}
+	return graph
+}
+
+// reversed returns a graph with every edge pointing the other way, so each key maps
+// to the keys of the objects that depend on it
+func (graph dependencyGraph) reversed() dependencyGraph {
+	reversed := make(dependencyGraph)
+	for dependent, referenced := range graph {
+		for _, ref := range referenced {
+			reversed[ref] = append(reversed[ref], dependent)
+		}
+	}
+	return reversed
+}
+
+// relationKey returns the object key for a relation given the type name used by
+// the OWNER and GRANT queries (TABLE, VIEW, SEQUENCE, FOREIGN TABLE)
+func relationKey(relType string, schema string, name string) string {
+	kind := "table"
+	switch relType {
+	case "VIEW":
+		kind = "view"
+	case "MATERIALIZED VIEW":
+		kind = "matview"
+	case "SEQUENCE":
+		kind = "sequence"
+	}
+	return kind + ":" + schema + "." + name
+}
+
+// ==================================
+// Ordering
+// ==================================
+
+// sortChanges orders the changes so that all drops come first, with each object
+// dropped before the objects it depends on in db2, followed by everything else, with
+// each object created after the objects it depends on in db1.  Changes that are not
+// related by any dependency keep the order in which they were generated.
+func sortChanges(changes []*SchemaChange, graph1 dependencyGraph, graph2 dependencyGraph) []*SchemaChange {
+	var drops, others []*SchemaChange
+	for _, change := range changes {
+		if change.Action == ActionDrop {
+			drops = append(drops, change)
+		} else {
+			others = append(others, change)
+		}
+	}
+	return append(sortByDependency(drops, graph2.reversed()), sortByDependency(others, graph1)...)
+}
+
+// sortByDependency topologically sorts the changes so that each one comes after the
+// changes for every object its key depends on in the graph
+func sortByDependency(changes []*SchemaChange, graph dependencyGraph) []*SchemaChange {
+	// Group the changes by object key, remembering where each key first appeared
+	changesByKey := make(map[string][]*SchemaChange)
+	priority := make(map[string]int)
+	for i, change := range changes {
+		if _, ok := priority[change.key]; !ok {
+			priority[change.key] = i
+		}
+		changesByKey[change.key] = append(changesByKey[change.key], change)
+	}
+
+	// Objects without changes are processed as soon as they can be so they never hold
+	// back the objects that depend on them
+	dependents := make(dependencyGraph)
+	pending := make(map[string]int)
+	for key, referenced := range graph {
+		for _, ref := range referenced {
+			dependents[ref] = append(dependents[ref], key)
+			if _, ok := priority[ref]; !ok {
+				priority[ref] = -1
+			}
+		}
+		if _, ok := priority[key]; !ok {
+			priority[key] = -1
+		}
+		pending[key] = len(referenced)
+	}
+
+	ready := &keyHeap{priority: priority}
+	for key := range priority {
+		if pending[key] == 0 {
+			heap.Push(ready, key)
+		}
+	}
+
+	sorted := make([]*SchemaChange, 0, len(changes))
+	done := make(map[string]bool)
+	for len(done) < len(priority) {
+		if ready.Len() == 0 {
+			// The remaining objects depend on each other, so break the cycle with
+			// whichever of them was generated first
+			first := breakCycle(priority, done)
+			if len(changesByKey[first]) > 0 {
+				changesByKey[first][0].addWarning("Circular dependency found involving %s.  These statements may need to be reordered by hand.", first)
+			}
+			heap.Push(ready, first)
+		}
+		key := heap.Pop(ready).(string)
+		if done[key] {
+			continue
+		}
+		done[key] = true
+		sorted = append(sorted, changesByKey[key]...)
+		for _, dependent := range dependents[key] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				heap.Push(ready, dependent)
+			}
+		}
+	}
+	return sorted
+}
+
+// breakCycle returns the first generated object that is not yet done
+func breakCycle(priority map[string]int, done map[string]bool) string {
+	first := ""
+	for key, p := range priority {
+		if !done[key] && (first == "" || p < priority[first] || (p == priority[first] && key < first)) {
+			first = key
+		}
+	}
+	return first
+}
+
+// ==================================
+// keyHeap definition
+// (implements heap.Interface)
+// ==================================
+
+// keyHeap is a min-heap of object keys ordered by their priority
+type keyHeap struct {
+	keys     []string
+	priority map[string]int
+}
+
+func (h keyHeap) Len() int {
+	return len(h.keys)
+}
+
+func (h keyHeap) Less(i, j int) bool {
+	pi, pj := h.priority[h.keys[i]], h.priority[h.keys[j]]
+	if pi != pj {
+		return pi < pj
+	}
+	return strings.Compare(h.keys[i], h.keys[j]) < 0
+}
+
+func (h keyHeap) Swap(i, j int) {
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+}
+
+func (h *keyHeap) Push(x interface{}) {
+	h.keys = append(h.keys, x.(string))
+}
+
+func (h *keyHeap) Pop() interface{} {
+	last := h.keys[len(h.keys)-1]
+	h.keys = h.keys[:len(h.keys)-1]
+	return last
+}
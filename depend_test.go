@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_sortChanges(t *testing.T) {
+	changes := []*SchemaChange{
+		{key: "view:s1.v2", Action: ActionAdd, Statements: []string{"CREATE VIEW s1.v2"}},
+		{key: "view:s1.v1", Action: ActionAdd, Statements: []string{"CREATE VIEW s1.v1"}},
+		{key: "table:s1.t1", Action: ActionAdd, Statements: []string{"CREATE TABLE s1.t1"}},
+		{key: "function:s1.f1", Action: ActionAdd, Statements: []string{"CREATE FUNCTION s1.f1"}},
+		{key: "table:s1.t2", Action: ActionDrop, Statements: []string{"DROP TABLE s1.t2"}},
+		{key: "view:s1.v3", Action: ActionDrop, Statements: []string{"DROP VIEW s1.v3"}},
+		{key: "role:r1", Action: ActionAdd, Statements: []string{"CREATE ROLE r1"}},
+	}
+	graph1 := dependencyGraph{
+		"view:s1.v2":        {"column:s1.v1.id"},
+		"column:s1.v1.id":   {"view:s1.v1"},
+		"view:s1.v1":        {"column:s1.t1.id"},
+		"column:s1.t1.id":   {"table:s1.t1", "function:s1.f1"},
+		"column:s1.t1.name": {"table:s1.t1"},
+	}
+	graph2 := dependencyGraph{
+		"view:s1.v3":      {"column:s1.t2.id"},
+		"column:s1.t2.id": {"table:s1.t2"},
+	}
+
+	expected := []string{
+		"DROP VIEW s1.v3",
+		"DROP TABLE s1.t2",
+		"CREATE TABLE s1.t1",
+		"CREATE FUNCTION s1.f1",
+		"CREATE VIEW s1.v1",
+		"CREATE VIEW s1.v2",
+		"CREATE ROLE r1",
+	}
+	sorted := sortChanges(changes, graph1, graph2)
+	if len(sorted) != len(expected) {
+		t.Fatalf("Wrong number of changes: %d instead of %d", len(sorted), len(expected))
+	}
+	for i, change := range sorted {
+		if change.Statements[0] != expected[i] {
+			t.Errorf("Change %d is %q instead of %q", i, change.Statements[0], expected[i])
+		}
+	}
+}
+
+func Test_sortChangesWithCycle(t *testing.T) {
+	changes := []*SchemaChange{
+		{key: "view:s1.a", Action: ActionAdd, Statements: []string{"CREATE VIEW s1.a"}},
+		{key: "view:s1.b", Action: ActionAdd, Statements: []string{"CREATE VIEW s1.b"}},
+	}
+	graph := dependencyGraph{
+		"view:s1.a": {"view:s1.b"},
+		"view:s1.b": {"view:s1.a"},
+	}
+	sorted := sortByDependency(changes, graph)
+	if len(sorted) != 2 || sorted[0].Statements[0] != "CREATE VIEW s1.a" {
+		t.Errorf("Cycle was not broken with the first generated change: %v", sorted)
+	}
+}
@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/template"
+
+	"github.com/joncrlsn/misc"
+)
+
+var (
+	extensionSqlTemplate = initExtensionSqlTemplate()
+)
+
+// Initializes the Sql template
+func initExtensionSqlTemplate() *template.Template {
+	sql := `
+SELECT e.extname AS compare_name
+    , e.extname AS extension_name
+    , n.nspname AS schema_name
+    , e.extversion AS version
+    , e.extrelocatable AS relocatable
+FROM pg_catalog.pg_extension AS e
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = e.extnamespace)
+WHERE {{$.SchemaFilter "n.nspname"}}
+`
+	t := template.New("ExtensionSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// ExtensionRows definition
+// ==================================
+
+// ExtensionRows is a sortable slice of string maps
+type ExtensionRows []map[string]string
+
+func (slice ExtensionRows) Len() int {
+	return len(slice)
+}
+
+func (slice ExtensionRows) Less(i, j int) bool {
+	return slice[i]["compare_name"] < slice[j]["compare_name"]
+}
+
+func (slice ExtensionRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// ExtensionSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// ExtensionSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type ExtensionSchema struct {
+	rows   ExtensionRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *ExtensionSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *ExtensionSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *ExtensionSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*ExtensionSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs an ExtensionSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	return val
+}
+
+// ObjectKey identifies the extension in the current row when ordering by dependency
+func (c *ExtensionSchema) ObjectKey() string {
+	return "extension:" + c.get("extension_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *ExtensionSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to create the extension
+func (c *ExtensionSchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("schema_name")
+	}
+	change := newChange("EXTENSION", ActionAdd, schema, c.get("extension_name"))
+	change.addSql("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s VERSION %s", quoteIdent(c.get("extension_name")), quoteIdent(schema), quoteLiteral(c.get("version")))
+	return change
+}
+
+// Drop returns SQL to drop the extension
+func (c *ExtensionSchema) Drop() *SchemaChange {
+	change := newChange("EXTENSION", ActionDrop, c.get("schema_name"), c.get("extension_name"))
+	change.Destructive = true
+	change.addWarning("Dropping an extension drops all of its objects.  It fails if anything else uses them.")
+	change.addSql("DROP EXTENSION %s", quoteIdent(c.get("extension_name")))
+	return change
+}
+
+// Change updates the extension to the db1 version and moves it to the db1 schema
+func (c *ExtensionSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*ExtensionSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, ExtensionSchema.Change(obj) needs an ExtensionSchema instance", c2)
+	}
+	change := newChange("EXTENSION", ActionChange, c2.get("schema_name"), c.get("extension_name"))
+
+	if c.get("version") != c2.get("version") {
+		change.addSql("ALTER EXTENSION %s UPDATE TO %s", quoteIdent(c.get("extension_name")), quoteLiteral(c.get("version")))
+	}
+
+	// Only compare the schemas when the same schemas are being compared
+	if dbInfo1.DbSchema == dbInfo2.DbSchema && c.get("schema_name") != c2.get("schema_name") {
+		if c2.get("relocatable") == "true" {
+			change.addSql("ALTER EXTENSION %s SET SCHEMA %s", quoteIdent(c.get("extension_name")), quoteIdent(c.get("schema_name")))
+		} else {
+			change.addWarning("WARNING: Extension %s is in schema %s in db1 and %s in db2, but it can't be moved.", c.get("extension_name"), c.get("schema_name"), c2.get("schema_name"))
+			change.addWarning("It must be dropped and created again by hand.")
+		}
+	}
+	return change
+}
+
+// compareExtensions returns the changes needed to make the extensions match between
+// two databases or schemas
+func compareExtensions(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := ExtensionRows(cat1.Query("EXTENSION", extensionSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := ExtensionRows(cat2.Query("EXTENSION", extensionSqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here
+	var schema1 Schema = &ExtensionSchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &ExtensionSchema{rows: rows2, rowNum: -1}
+
+	// Compare the extensions
+	return doDiff(schema1, schema2)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ExtensionSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row1 := map[string]string{"extension_name": "pg_trgm", "schema_name": "public", "version": "1.6", "relocatable": "true"}
+	row2 := map[string]string{"extension_name": "pg_trgm", "schema_name": "ext", "version": "1.5", "relocatable": "true"}
+
+	c1 := &ExtensionSchema{rows: ExtensionRows{row1}, rowNum: 0}
+	c2 := &ExtensionSchema{rows: ExtensionRows{row2}, rowNum: 0}
+	expectStatements(t, c1.Add(), `CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public VERSION '1.6'`)
+	expectStatements(t, c1.Drop(), `DROP EXTENSION pg_trgm`)
+	expectStatements(t, c1.Change(c2),
+		`ALTER EXTENSION pg_trgm UPDATE TO '1.6'`,
+		`ALTER EXTENSION pg_trgm SET SCHEMA public`)
+
+	// An extension that isn't relocatable can't be moved
+	row2["relocatable"], row2["version"] = "false", "1.6"
+	change := c1.Change(c2)
+	if len(change.Statements) != 0 || len(change.Warnings) == 0 {
+		t.Errorf("Expected only a warning: %q %q", change.Statements, change.Warnings)
+	}
+}
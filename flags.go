@@ -18,7 +18,7 @@ func parseFlags() (pgutil.DbInfo, pgutil.DbInfo) {
 	var dbHost1 = flag.StringP("host1", "H", "localhost", "db host")
 	var dbPort1 = flag.IntP("port1", "P", 5432, "db port")
 	var dbName1 = flag.StringP("dbname1", "D", "", "db name")
-	var dbSchema1 = flag.StringP("schema1", "S", "*", "schema name or * for all schemas")
+	var dbSchema1 = flag.StringP("schema1", "S", "*", "schema name, comma separated schema names, or * for all schemas")
 	var dbOptions1 = flag.StringP("options1", "O", "", "db options (eg. sslmode=disable)")
 
 	var dbUser2 = flag.StringP("user2", "u", "", "db user")
@@ -26,9 +26,18 @@ func parseFlags() (pgutil.DbInfo, pgutil.DbInfo) {
 	var dbHost2 = flag.StringP("host2", "h", "localhost", "db host")
 	var dbPort2 = flag.IntP("port2", "p", 5432, "db port")
 	var dbName2 = flag.StringP("dbname2", "d", "", "db name")
-	var dbSchema2 = flag.StringP("schema2", "s", "*", "schema name or * for all schemas")
+	var dbSchema2 = flag.StringP("schema2", "s", "*", "schema name, comma separated schema names, or * for all schemas")
 	var dbOptions2 = flag.StringP("options2", "o", "", "db options (eg. sslmode=disable)")
 
+	flag.StringVar(&snapshotFile1, "snapshot1", "", "snapshot file to use instead of the first database")
+	flag.StringVar(&snapshotFile2, "snapshot2", "", "snapshot file to use instead of the second database")
+	flag.StringVar(&outputFormat, "format", "sql", "output format: sql, json, or yaml")
+	flag.BoolVar(&checkOnly, "check", false, "summarize differences and exit 1 if there are any")
+	flag.BoolVar(&applyChanges, "apply", false, "run the generated SQL against db2 in a transaction")
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "with --apply, skip statements that fail and commit the rest")
+	flag.BoolVar(&syncSequenceValues, "sync-sequence-values", false, "move db2 sequences forward to their db1 values")
+	flag.StringVar(&functionLanguages, "languages", "c,plpgsql,sql", "comma separated languages of the functions to compare, or * for all")
+
 	flag.Parse()
 
 	dbInfo1 := pgutil.DbInfo{DbName: *dbName1, DbHost: *dbHost1, DbPort: int32(*dbPort1), DbUser: *dbUser1, DbPass: *dbPass1, DbSchema: *dbSchema1, DbOptions: *dbOptions1}
@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"text/template"
 )
@@ -32,12 +30,12 @@ FROM pg_catalog.pg_constraint c
 INNER JOIN pg_class AS cl ON (c.conrelid = cl.oid)
 INNER JOIN pg_namespace AS ns ON (ns.oid = c.connamespace)
 WHERE c.contype = 'f'
-{{if eq $.DbSchema "*"}}
-AND ns.nspname NOT LIKE 'pg_%' 
-AND ns.nspname <> 'information_schema' 
-{{else}}
-AND ns.nspname = '{{$.DbSchema}}'
-{{end}}
+-- Leave out the copies of a partitioned table's foreign keys on its partitions
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend AS d
+    WHERE d.classid = 'pg_constraint'::regclass AND d.objid = c.oid
+    AND d.refclassid = 'pg_constraint'::regclass AND d.deptype IN ('i', 'P'))
+AND {{$.SchemaFilter "ns.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "cl.oid"}}
 `
 	t := template.New("ForeignKeySqlTmpl")
 	template.Must(t.Parse(sql))
@@ -108,7 +106,7 @@ func (c *ForeignKeySchema) NextRow() bool {
 func (c *ForeignKeySchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*ForeignKeySchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a ForeignKeySchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a ForeignKeySchema instance", c2)
 		return +999
 	}
 
@@ -122,53 +120,58 @@ func (c *ForeignKeySchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the foreign key in the current row when ordering by dependency
+func (c *ForeignKeySchema) ObjectKey() string {
+	return "foreign_key:" + c.get("schema_name") + "." + c.get("table_name") + "." + c.get("fk_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *ForeignKeySchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to add the foreign key
-func (c *ForeignKeySchema) Add() {
+func (c *ForeignKeySchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("schema_name")
 	}
-	fmt.Printf("ALTER TABLE %s.%s ADD CONSTRAINT %s %s;\n", schema, c.get("table_name"), c.get("fk_name"), c.get("constraint_def"))
+	change := newChange("FOREIGN_KEY", ActionAdd, schema, c.get("table_name")+"."+c.get("fk_name"))
+	change.addSql("ALTER TABLE %s ADD CONSTRAINT %s %s", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("fk_name")), c.get("constraint_def"))
+	return change
 }
 
 // Drop returns SQL to drop the foreign key
-func (c ForeignKeySchema) Drop() {
-	fmt.Printf("ALTER TABLE %s.%s DROP CONSTRAINT %s; -- %s\n", c.get("schema_name"), c.get("table_name"), c.get("fk_name"), c.get("constraint_def"))
+func (c ForeignKeySchema) Drop() *SchemaChange {
+	change := newChange("FOREIGN_KEY", ActionDrop, c.get("schema_name"), c.get("table_name")+"."+c.get("fk_name"))
+	change.Destructive = true
+	change.addWarning("Dropping %s", c.get("constraint_def"))
+	change.addSql("ALTER TABLE %s DROP CONSTRAINT %s", qualifiedName(c.get("schema_name"), c.get("table_name")), quoteIdent(c.get("fk_name")))
+	return change
 }
 
 // Change handles the case where the table and foreign key name, but the details do not
-func (c *ForeignKeySchema) Change(obj interface{}) {
+func (c *ForeignKeySchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*ForeignKeySchema)
 	if !ok {
-		fmt.Println("Error!!!, ForeignKeySchema.Change(obj) needs a ForeignKeySchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, ForeignKeySchema.Change(obj) needs a ForeignKeySchema instance", c2)
 	}
 	// There is no "changing" a foreign key.  It either gets created or dropped (or left as-is).
+	return nil
 }
 
 /*
  * Compare the foreign keys in the two databases.
  */
-func compareForeignKeys(conn1 *sql.DB, conn2 *sql.DB) {
+func compareForeignKeys(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	buf1 := new(bytes.Buffer)
-	foreignKeySqlTemplate.Execute(buf1, dbInfo1)
-
-	buf2 := new(bytes.Buffer)
-	foreignKeySqlTemplate.Execute(buf2, dbInfo2)
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	rows1 := make(ForeignKeyRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := ForeignKeyRows(cat1.Query("FOREIGN_KEY", foreignKeySqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(ForeignKeyRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := ForeignKeyRows(cat2.Query("FOREIGN_KEY", foreignKeySqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here for some unknown reason
@@ -176,5 +179,5 @@ func compareForeignKeys(conn1 *sql.DB, conn2 *sql.DB) {
 	var schema2 Schema = &ForeignKeySchema{rows: rows2, rowNum: -1}
 
 	// Compare the foreign keys
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
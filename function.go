@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -25,22 +23,74 @@ var (
 func initFunctionSqlTemplate() *template.Template {
 	sql := `
     SELECT n.nspname                 AS schema_name
-        , {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}p.proname AS compare_name
+        , {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}p.proname
+            || '(' || pg_catalog.oidvectortypes(p.proargtypes) || ')' AS compare_name
         , p.proname                  AS function_name
         , p.oid::regprocedure        AS fancy
+        , pg_catalog.oidvectortypes(p.proargtypes) AS arg_types
+        , pg_catalog.pg_get_function_identity_arguments(p.oid) AS arguments
+        , CASE p.prokind WHEN 'a' THEN 'AGGREGATE' WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END AS function_type
+        , l.lanname                  AS language
         , t.typname                  AS return_type
-        , pg_get_functiondef(p.oid)  AS definition
+        , pg_catalog.pg_get_function_arguments(p.oid) AS function_args
+        , pg_catalog.pg_get_function_result(p.oid) AS result
+        -- The body, to tell whether only the attributes below are different.  prosqlbody
+        -- (a BEGIN ATOMIC body) is read through jsonb because older versions don't have it.
+        , md5(concat_ws(E'\n', p.prosrc, p.probin, to_jsonb(p) ->> 'prosqlbody')) AS source_hash
+        , p.provolatile              AS volatility
+        , p.proisstrict              AS strict
+        , p.prosecdef                AS security_definer
+        , p.proleakproof             AS leakproof
+        , p.proparallel              AS parallel
+        , p.procost                  AS cost
+        , p.prorows                  AS rows
+        , p.proconfig                AS config
+        -- What a DROP ... CASCADE would also drop
+        , ARRAY(SELECT DISTINCT pg_catalog.pg_describe_object(d.classid, d.objid, d.objsubid)
+            FROM pg_catalog.pg_depend AS d
+            WHERE d.refclassid = 'pg_proc'::regclass
+            AND d.refobjid = p.oid
+            AND d.deptype = 'n'
+            ORDER BY 1) AS dependents
+        -- pg_get_functiondef() can't rebuild an aggregate, so its definition comes
+        -- from pg_aggregate
+        , CASE WHEN p.prokind <> 'a' THEN pg_get_functiondef(p.oid)
+          ELSE 'CREATE AGGREGATE ' || quote_ident(n.nspname) || '.' || quote_ident(p.proname)
+            || '(' || pg_catalog.pg_get_function_arguments(p.oid) || ') (' || E'\n    '
+            || concat_ws(E',\n    '
+                , 'SFUNC = ' || a.aggtransfn::regproc
+                , 'STYPE = ' || pg_catalog.format_type(a.aggtranstype, NULL)
+                , CASE WHEN a.aggtransspace <> 0 THEN 'SSPACE = ' || a.aggtransspace END
+                , CASE WHEN a.aggfinalfn <> 0 THEN 'FINALFUNC = ' || a.aggfinalfn::regproc END
+                , CASE WHEN a.aggfinalextra THEN 'FINALFUNC_EXTRA' END
+                , CASE WHEN a.aggfinalfn <> 0 THEN 'FINALFUNC_MODIFY = '
+                  || CASE a.aggfinalmodify WHEN 'r' THEN 'READ_ONLY' WHEN 's' THEN 'SHAREABLE' ELSE 'READ_WRITE' END END
+                , CASE WHEN a.aggcombinefn <> 0 THEN 'COMBINEFUNC = ' || a.aggcombinefn::regproc END
+                , CASE WHEN a.aggserialfn <> 0 THEN 'SERIALFUNC = ' || a.aggserialfn::regproc END
+                , CASE WHEN a.aggdeserialfn <> 0 THEN 'DESERIALFUNC = ' || a.aggdeserialfn::regproc END
+                , 'INITCOND = ' || quote_literal(a.agginitval)
+                , CASE WHEN a.aggmtransfn <> 0 THEN 'MSFUNC = ' || a.aggmtransfn::regproc END
+                , CASE WHEN a.aggminvtransfn <> 0 THEN 'MINVFUNC = ' || a.aggminvtransfn::regproc END
+                , CASE WHEN a.aggmtranstype <> 0 THEN 'MSTYPE = ' || pg_catalog.format_type(a.aggmtranstype, NULL) END
+                , CASE WHEN a.aggmtransspace <> 0 THEN 'MSSPACE = ' || a.aggmtransspace END
+                , CASE WHEN a.aggmfinalfn <> 0 THEN 'MFINALFUNC = ' || a.aggmfinalfn::regproc END
+                , CASE WHEN a.aggmfinalextra THEN 'MFINALFUNC_EXTRA' END
+                , CASE WHEN a.aggmfinalfn <> 0 THEN 'MFINALFUNC_MODIFY = '
+                  || CASE a.aggmfinalmodify WHEN 'r' THEN 'READ_ONLY' WHEN 's' THEN 'SHAREABLE' ELSE 'READ_WRITE' END END
+                , 'MINITCOND = ' || quote_literal(a.aggminitval)
+                , (SELECT 'SORTOP = OPERATOR(' || op.oprnamespace::regnamespace || '.' || op.oprname || ')'
+                   FROM pg_catalog.pg_operator AS op WHERE op.oid = a.aggsortop)
+                , CASE p.proparallel WHEN 's' THEN 'PARALLEL = SAFE' WHEN 'r' THEN 'PARALLEL = RESTRICTED' END
+                , CASE WHEN a.aggkind = 'h' THEN 'HYPOTHETICAL' END)
+            || E'\n)' END AS definition
     FROM pg_proc AS p
-    JOIN pg_type t ON (p.prorettype = t.oid)
+    LEFT JOIN pg_type t ON (p.prorettype = t.oid)
     JOIN pg_namespace n ON (n.oid = p.pronamespace)
-    JOIN pg_language l ON (p.prolang = l.oid AND l.lanname IN ('c','plpgsql', 'sql'))
+    JOIN pg_language l ON (p.prolang = l.oid)
+    LEFT JOIN pg_aggregate a ON (a.aggfnoid = p.oid)
     WHERE true
-	{{if eq $.DbSchema "*" }}
-    AND n.nspname NOT LIKE 'pg_%' 
-    AND n.nspname <> 'information_schema' 
-    {{else}}
-    AND n.nspname = '{{$.DbSchema}}'
-    {{end}};
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_proc" "p.oid"}};
 	`
 	t := template.New("FunctionSqlTmpl")
 	template.Must(t.Parse(sql))
@@ -97,7 +147,7 @@ func (c *FunctionSchema) NextRow() bool {
 func (c *FunctionSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*FunctionSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a FunctionSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a FunctionSchema instance", c2)
 		return +999
 	}
 
@@ -106,85 +156,229 @@ func (c *FunctionSchema) Compare(obj interface{}) int {
 	return val
 }
 
-// Add returns SQL to create the function
-func (c FunctionSchema) Add() {
-	// If we are comparing two different schemas against each other, we need to do some
-	// modification of the first function definition so we create it in the right schema
+// ObjectKey identifies the function in the current row when ordering by dependency
+func (c *FunctionSchema) ObjectKey() string {
+	return "function:" + c.get("schema_name") + "." + c.get("function_name") + "(" + c.get("arg_types") + ")"
+}
+
+// signature returns the name of the function with the types of its arguments, which
+// is what identifies one of several functions with the same name
+func (c *FunctionSchema) signature() string {
+	return c.get("function_name") + "(" + c.get("arg_types") + ")"
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *FunctionSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// definition returns the CREATE statement for the function, procedure, or aggregate.
+// If we are comparing two different schemas against each other, we need to do some
+// modification of the first definition so we create it in the right schema.
+func (c *FunctionSchema) definition() string {
 	functionDef := c.get("definition")
 	if dbInfo1.DbSchema != dbInfo2.DbSchema {
 		functionDef = strings.Replace(
 			functionDef,
-			fmt.Sprintf("FUNCTION %s.%s(", c.get("schema_name"), c.get("function_name")),
-			fmt.Sprintf("FUNCTION %s.%s(", dbInfo2.DbSchema, c.get("function_name")),
+			c.get("function_type")+" "+qualifiedName(c.get("schema_name"), c.get("function_name"))+"(",
+			c.get("function_type")+" "+qualifiedName(dbInfo2.DbSchema, c.get("function_name"))+"(",
 			-1)
 	}
+	return functionDef
+}
 
-	fmt.Println("-- STATEMENT-BEGIN")
-	fmt.Println(functionDef, ";")
-	fmt.Println("-- STATEMENT-END")
+// Add returns SQL to create the function
+func (c FunctionSchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("schema_name")
+	}
+	change := newChange("FUNCTION", ActionAdd, schema, c.signature())
+	change.addSql("%s", c.definition())
+	return change
 }
 
 // Drop returns SQL to drop the function
-func (c FunctionSchema) Drop() {
-	fmt.Println("-- Note that CASCADE in the statement below will also drop any triggers depending on this function.")
-	fmt.Println("-- Also, if there are two functions with this name, you will want to add arguments to identify the correct one to drop.")
-	fmt.Println("-- (See http://www.postgresql.org/docs/9.4/interactive/sql-dropfunction.html) ")
-	fmt.Printf("DROP FUNCTION %s.%s CASCADE;\n", c.get("schema_name"), c.get("function_name"))
+func (c FunctionSchema) Drop() *SchemaChange {
+	change := newChange("FUNCTION", ActionDrop, c.get("schema_name"), c.signature())
+	change.Destructive = true
+	change.addWarning("Note that CASCADE in the statement below will also drop any triggers depending on this function.")
+	c.addDrop(change, c.get("schema_name"))
+	return change
+}
+
+// addDrop adds the SQL to drop the function, procedure, or aggregate from the given schema
+func (c *FunctionSchema) addDrop(change *SchemaChange, schema string) {
+	change.addSql("DROP %s %s(%s) CASCADE", c.get("function_type"), qualifiedName(schema, c.get("function_name")), c.get("arguments"))
+}
+
+// addCascadingDrop adds the SQL to drop the db2 function before it is created again,
+// with a warning that lists the objects the CASCADE drops with it
+func (c *FunctionSchema) addCascadingDrop(change *SchemaChange) {
+	change.Destructive = true
+	if dependents := parseArray(c.get("dependents")); len(dependents) > 0 {
+		change.addWarning("The CASCADE below also drops these, which must be created again by hand:")
+		for _, dependent := range dependents {
+			change.addWarning("   %s", dependent)
+		}
+	}
+	c.addDrop(change, c.get("schema_name"))
 }
 
 // Change handles the case where the function names match, but the definition does not
-func (c FunctionSchema) Change(obj interface{}) {
+func (c FunctionSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*FunctionSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a FunctionSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a FunctionSchema instance", c2)
 	}
+	change := newChange("FUNCTION", ActionChange, c2.get("schema_name"), c.signature())
 	if c.get("definition") != c2.get("definition") {
-		fmt.Println("-- This function is different so we'll recreate it:")
-
-		// If we are comparing two different schemas against each other, we need to do some
-		// modification of the first function definition so we create it in the right schema
-		functionDef := c.get("definition")
-		if dbInfo1.DbSchema != dbInfo2.DbSchema {
-			functionDef = strings.Replace(
-				functionDef,
-				fmt.Sprintf("FUNCTION %s.%s(", c.get("schema_name"), c.get("function_name")),
-				fmt.Sprintf("FUNCTION %s.%s(", dbInfo2.DbSchema, c.get("function_name")),
-				-1)
+		switch {
+		case c.get("function_type") == "AGGREGATE" || c.get("function_type") != c2.get("function_type"):
+			// An aggregate (or a function that became a procedure) can't be replaced,
+			// so it is dropped first
+			change.addWarning("This function is different so we'll recreate it:")
+			c2.addCascadingDrop(change)
+			change.addSql("%s", c.definition())
+		case c.get("result") != c2.get("result") || c.get("function_args") != c2.get("function_args"):
+			// CREATE OR REPLACE can't change the return type (which includes the OUT
+			// parameters) or rename the parameters
+			change.addWarning("WARNING: The return type or parameters of this function changed so it must be dropped and created again.")
+			c2.addCascadingDrop(change)
+			change.addSql("%s", c.definition())
+		case c.get("language") != c2.get("language") || c.get("source_hash") != c2.get("source_hash"):
+			change.addWarning("This function is different so we'll recreate it:")
+			// The definition column has everything needed to rebuild the function
+			change.addSql("%s", c.definition())
+		default:
+			// Only the attributes are different
+			c.addAlters(change, c2)
+		}
+	}
+	return change
+}
+
+// target returns the function with its arguments as it is written after ALTER
+func (c *FunctionSchema) target() string {
+	return c.get("function_type") + " " + qualifiedName(c.get("schema_name"), c.get("function_name")) + "(" + c.get("arguments") + ")"
+}
+
+// listSettings are the settings whose values are lists, which are written without quotes
+var listSettings = map[string]bool{"search_path": true, "temp_tablespaces": true}
+
+// addAlters adds an ALTER statement for each attribute of the db2 function (c2) that
+// is different from the db1 function
+func (c *FunctionSchema) addAlters(change *SchemaChange, c2 *FunctionSchema) {
+	target := c2.target()
+	if c.get("volatility") != c2.get("volatility") {
+		volatility := map[string]string{"i": "IMMUTABLE", "s": "STABLE", "v": "VOLATILE"}
+		change.addSql("ALTER %s %s", target, volatility[c.get("volatility")])
+	}
+	if c.get("strict") != c2.get("strict") {
+		if c.get("strict") == "true" {
+			change.addSql("ALTER %s STRICT", target)
+		} else {
+			change.addSql("ALTER %s CALLED ON NULL INPUT", target)
 		}
+	}
+	if c.get("security_definer") != c2.get("security_definer") {
+		if c.get("security_definer") == "true" {
+			change.addSql("ALTER %s SECURITY DEFINER", target)
+		} else {
+			change.addSql("ALTER %s SECURITY INVOKER", target)
+		}
+	}
+	if c.get("leakproof") != c2.get("leakproof") {
+		if c.get("leakproof") == "true" {
+			change.addSql("ALTER %s LEAKPROOF", target)
+		} else {
+			change.addSql("ALTER %s NOT LEAKPROOF", target)
+		}
+	}
+	if c.get("parallel") != c2.get("parallel") {
+		parallel := map[string]string{"s": "SAFE", "r": "RESTRICTED", "u": "UNSAFE"}
+		change.addSql("ALTER %s PARALLEL %s", target, parallel[c.get("parallel")])
+	}
+	if c.get("cost") != c2.get("cost") {
+		change.addSql("ALTER %s COST %s", target, c.get("cost"))
+	}
+	if c.get("rows") != c2.get("rows") && c.get("rows") != "0" {
+		change.addSql("ALTER %s ROWS %s", target, c.get("rows"))
+	}
 
-		// The definition column has everything needed to rebuild the function
-		fmt.Println("-- STATEMENT-BEGIN")
-		fmt.Printf("%s;\n", functionDef)
-		fmt.Println("-- STATEMENT-END")
+	// The settings are name=value pairs
+	settings1, settings2 := functionSettings(c.get("config")), functionSettings(c2.get("config"))
+	var names []string
+	for name := range settings1 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := settings2[name]; !ok || value != settings1[name] {
+			value = settings1[name]
+			if !listSettings[name] {
+				value = quoteLiteral(value)
+			}
+			change.addSql("ALTER %s SET %s = %s", target, name, value)
+		}
+	}
+	names = nil
+	for name := range settings2 {
+		if _, ok := settings1[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		change.addSql("ALTER %s RESET %s", target, name)
 	}
 }
 
+// functionSettings returns the SET name = value settings of a function from its
+// proconfig array
+func functionSettings(config string) map[string]string {
+	settings := make(map[string]string)
+	for _, setting := range parseArray(config) {
+		if i := strings.Index(setting, "="); i > 0 {
+			settings[setting[:i]] = setting[i+1:]
+		}
+	}
+	return settings
+}
+
 // ==================================
 // Functions
 // ==================================
 
-// compareFunctions outputs SQL to make the functions match between DBs
-func compareFunctions(conn1 *sql.DB, conn2 *sql.DB) {
-
-	buf1 := new(bytes.Buffer)
-	functionSqlTemplate.Execute(buf1, dbInfo1)
-
-	buf2 := new(bytes.Buffer)
-	functionSqlTemplate.Execute(buf2, dbInfo2)
+// filterLanguages leaves out the functions and procedures written in a language that
+// is not in --languages.  Aggregates are kept because they have no language of their
+// own (pg_proc says internal).
+func filterLanguages(rows []map[string]string) FunctionRows {
+	// --languages is a comma separated list just like --schema1, or * for all
+	languages := parseSchemas(functionLanguages)
+	if len(languages) == 0 {
+		return FunctionRows(rows)
+	}
+	var filtered FunctionRows
+	for _, row := range rows {
+		// Aggregates and other objects (in the OWNER rows) have no language to filter on
+		if row["function_type"] == "AGGREGATE" || row["language"] == "null" || misc.ContainsString(languages, row["language"]) {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
+// compareFunctions returns the changes needed to make the functions match between DBs
+func compareFunctions(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	rows1 := make(FunctionRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := filterLanguages(cat1.Query("FUNCTION", functionSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(FunctionRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := filterLanguages(cat2.Query("FUNCTION", functionSqlTemplate))
 	sort.Sort(rows2)
 
 	// We must explicitly type this as Schema here
@@ -192,5 +386,5 @@ func compareFunctions(conn1 *sql.DB, conn2 *sql.DB) {
 	var schema2 Schema = &FunctionSchema{rows: rows2, rowNum: -1}
 
 	// Compare the functions
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+)
+
+// Overloaded functions are told apart by the types of their arguments
+func Test_compareFunctionsOverloads(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	function := func(argTypes, arguments, body string) map[string]string {
+		return map[string]string{"schema_name": "s1", "compare_name": "s1.total(" + argTypes + ")", "function_name": "total",
+			"arg_types": argTypes, "arguments": arguments, "function_type": "FUNCTION", "language": "sql",
+			"definition": "CREATE OR REPLACE FUNCTION s1.total(" + arguments + ")\n AS $$ " + body + " $$"}
+	}
+	rows1 := []map[string]string{
+		function("integer", "a integer", "SELECT a"),
+		function("integer, integer", "a integer, b integer", "SELECT a + b"),
+	}
+	rows2 := []map[string]string{
+		function("integer", "a integer", "SELECT a"),
+		function("numeric", "a numeric", "SELECT a"),
+	}
+
+	changes := compareFunctions(testCatalog{"FUNCTION": rows1}, testCatalog{"FUNCTION": rows2})
+	if len(changes) != 2 {
+		t.Fatalf("Expected one function to be added and one dropped: %v", changes)
+	}
+	if changes[0].Action != ActionAdd || changes[0].Name != "s1.total(integer, integer)" {
+		t.Errorf("Expected total(integer, integer) to be added: %s %s", changes[0].Action, changes[0].Name)
+	}
+	expectStatements(t, changes[1], "DROP FUNCTION s1.total(a numeric) CASCADE")
+}
+
+func Test_FunctionSchemaKinds(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "s1", "s2"
+	aggregate := map[string]string{"schema_name": "s1", "function_name": "product", "arg_types": "numeric", "arguments": "numeric",
+		"function_type": "AGGREGATE", "language": "internal",
+		"definition": "CREATE AGGREGATE s1.product(numeric) (\n    SFUNC = numeric_mul,\n    STYPE = numeric,\n    INITCOND = '1'\n)"}
+	old := map[string]string{"schema_name": "s2", "function_name": "product", "arg_types": "numeric", "arguments": "numeric",
+		"function_type": "AGGREGATE", "language": "internal", "dependents": "{\"view s2.totals\"}",
+		"definition": "CREATE AGGREGATE s2.product(numeric) (\n    SFUNC = numeric_mul,\n    STYPE = numeric\n)"}
+
+	// Aggregates can't be replaced, so they are dropped and created again
+	c1 := &FunctionSchema{rows: FunctionRows{aggregate}, rowNum: 0}
+	c2 := &FunctionSchema{rows: FunctionRows{old}, rowNum: 0}
+	change := c1.Change(c2)
+	expectStatements(t, change,
+		"DROP AGGREGATE s2.product(numeric) CASCADE",
+		"CREATE AGGREGATE s2.product(numeric) (\n    SFUNC = numeric_mul,\n    STYPE = numeric,\n    INITCOND = '1'\n)")
+	if !change.Destructive || len(change.Warnings) != 3 || change.Warnings[2] != "   view s2.totals" {
+		t.Errorf("The drop should be destructive and list what it drops: %v %q", change.Destructive, change.Warnings)
+	}
+
+	procedure := map[string]string{"schema_name": "s1", "function_name": "archive", "arg_types": "integer", "arguments": "days integer",
+		"function_type": "PROCEDURE", "language": "plpgsql",
+		"definition": "CREATE OR REPLACE PROCEDURE s1.archive(days integer)\n LANGUAGE plpgsql\nAS $procedure$ BEGIN END $procedure$\n"}
+	c1 = &FunctionSchema{rows: FunctionRows{procedure}, rowNum: 0}
+	expectStatements(t, c1.Add(), "CREATE OR REPLACE PROCEDURE s2.archive(days integer)\n LANGUAGE plpgsql\nAS $procedure$ BEGIN END $procedure$\n")
+	expectStatements(t, c1.Drop(), "DROP PROCEDURE s1.archive(days integer) CASCADE")
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+
+	// Only the functions in the languages asked for are compared, but aggregates are kept
+	plv8 := map[string]string{"function_name": "render", "function_type": "FUNCTION", "language": "plv8"}
+	functionLanguages = "plpgsql, sql"
+	defer func() { functionLanguages = "*" }()
+	rows := filterLanguages([]map[string]string{aggregate, procedure, plv8})
+	if len(rows) != 2 || rows[0]["function_name"] != "product" || rows[1]["function_name"] != "archive" {
+		t.Errorf("Wrong functions: %v", rows)
+	}
+}
+
+func Test_FunctionSchemaChange(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	function := func() map[string]string {
+		return map[string]string{"schema_name": "s1", "function_name": "total", "arg_types": "integer", "arguments": "a integer",
+			"function_type": "FUNCTION", "language": "sql", "function_args": "a integer", "result": "integer",
+			"source_hash": "abc", "volatility": "v", "strict": "false", "security_definer": "false", "leakproof": "false",
+			"parallel": "u", "cost": "100", "rows": "0", "config": "null", "dependents": "{}",
+			"definition": "CREATE OR REPLACE FUNCTION s1.total(a integer)\n RETURNS integer\n LANGUAGE sql\nAS $function$ SELECT a $function$\n"}
+	}
+	row1, row2 := function(), function()
+	row1["definition"] = "CREATE OR REPLACE FUNCTION s1.total(a integer)\n RETURNS integer\n LANGUAGE sql\n IMMUTABLE STRICT PARALLEL SAFE COST 10\n SET search_path TO 'public', 'pg_temp'\n SET work_mem TO '64MB'\nAS $function$ SELECT a $function$\n"
+	row1["volatility"], row1["strict"], row1["parallel"], row1["cost"] = "i", "true", "s", "10"
+	row1["config"], row2["config"] = `{"search_path=public, pg_temp",work_mem=64MB}`, `{statement_timeout=5s}`
+
+	// Only the attributes are different, so they are altered
+	c1 := &FunctionSchema{rows: FunctionRows{row1}, rowNum: 0}
+	c2 := &FunctionSchema{rows: FunctionRows{row2}, rowNum: 0}
+	expectStatements(t, c1.Change(c2),
+		"ALTER FUNCTION s1.total(a integer) IMMUTABLE",
+		"ALTER FUNCTION s1.total(a integer) STRICT",
+		"ALTER FUNCTION s1.total(a integer) PARALLEL SAFE",
+		"ALTER FUNCTION s1.total(a integer) COST 10",
+		"ALTER FUNCTION s1.total(a integer) SET search_path = public, pg_temp",
+		"ALTER FUNCTION s1.total(a integer) SET work_mem = '64MB'",
+		"ALTER FUNCTION s1.total(a integer) RESET statement_timeout")
+
+	// A different body is replaced
+	row1 = function()
+	row1["source_hash"] = "def"
+	row1["definition"] = "CREATE OR REPLACE FUNCTION s1.total(a integer)\n RETURNS integer\n LANGUAGE sql\nAS $function$ SELECT a + 1 $function$\n"
+	c1 = &FunctionSchema{rows: FunctionRows{row1}, rowNum: 0}
+	expectStatements(t, c1.Change(c2), row1["definition"])
+
+	// A different return type can't be replaced, and whatever depends on the function
+	// is named
+	row1["result"] = "bigint"
+	row2["dependents"] = `{"view s1.totals","trigger audit on table s1.account"}`
+	change := c1.Change(c2)
+	expectStatements(t, change, "DROP FUNCTION s1.total(a integer) CASCADE", row1["definition"])
+	if !change.Destructive || len(change.Warnings) != 4 || change.Warnings[2] != "   view s1.totals" {
+		t.Errorf("Expected a destructive change naming the dependents: %q", change.Warnings)
+	}
+}
@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -30,6 +28,7 @@ SELECT
   , {{ if eq $.DbSchema "*" }}n.nspname::text || '.' || {{ end }}c.relkind::text  || '.' || c.relname::text || '.' || a.attname AS compare_name
   , CASE c.relkind
     WHEN 'r' THEN 'TABLE'
+    WHEN 'p' THEN 'TABLE'
     WHEN 'v' THEN 'VIEW'
     WHEN 'f' THEN 'FOREIGN TABLE'
     END as type
@@ -42,14 +41,10 @@ INNER JOIN (SELECT attname, unnest(attacl) AS attacl, attrelid
            FROM pg_catalog.pg_attribute
            WHERE NOT attisdropped AND attacl IS NOT NULL)
       AS a ON (a.attrelid = c.oid)
-WHERE c.relkind IN ('r', 'v', 'f')
+WHERE c.relkind IN ('r', 'p', 'v', 'f')
 --AND pg_catalog.pg_table_is_visible(c.oid)
-{{ if eq $.DbSchema "*" }}
-AND n.nspname NOT LIKE 'pg_%'
-AND n.nspname <> 'information_schema'
-{{ else }}
-AND n.nspname = '{{ $.DbSchema }}'
-{{ end }};
+AND {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}};
 `
 
 	t := template.New("GrantAttributeSqlTmpl")
@@ -132,7 +127,7 @@ func (c *GrantAttributeSchema) NextRow() bool {
 func (c *GrantAttributeSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*GrantAttributeSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare needs a GrantAttributeSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a GrantAttributeSchema instance", c2)
 		return +999
 	}
 
@@ -147,30 +142,49 @@ func (c *GrantAttributeSchema) Compare(obj interface{}) int {
 	return val
 }
 
-// Add prints SQL to add the grant
-func (c *GrantAttributeSchema) Add() {
+// ObjectKey identifies the column the grant is on in the current row when ordering by dependency
+func (c *GrantAttributeSchema) ObjectKey() string {
+	return "column:" + c.get("schema_name") + "." + c.get("relationship_name") + "." + c.get("attribute_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *GrantAttributeSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to add the grant
+func (c *GrantAttributeSchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("schema_name")
 	}
+	change := newChange("GRANT_ATTRIBUTE", ActionAdd, schema, c.get("relationship_name")+"."+c.get("attribute_name"))
 
 	role, grants := parseGrants(c.get("attribute_acl"))
-	fmt.Printf("GRANT %s (%s) ON %s.%s TO %s; -- Add\n", strings.Join(grants, ", "), c.get("attribute_name"), schema, c.get("relationship_name"), role)
+	change.addSql("GRANT %s (%s) ON %s TO %s", strings.Join(grants, ", "), quoteIdent(c.get("attribute_name")), qualifiedName(schema, c.get("relationship_name")), quoteIdent(role))
+	return change
 }
 
-// Drop prints SQL to drop the grant
-func (c *GrantAttributeSchema) Drop() {
+// Drop returns SQL to drop the grant
+func (c *GrantAttributeSchema) Drop() *SchemaChange {
+	change := newChange("GRANT_ATTRIBUTE", ActionDrop, c.get("schema_name"), c.get("relationship_name")+"."+c.get("attribute_name"))
 	role, grants := parseGrants(c.get("attribute_acl"))
-	fmt.Printf("REVOKE %s (%s) ON %s.%s FROM %s; -- Drop\n", strings.Join(grants, ", "), c.get("attribute_name"), c.get("schema_name"), c.get("relationship_name"), role)
+	change.addSql("REVOKE %s (%s) ON %s FROM %s", strings.Join(grants, ", "), quoteIdent(c.get("attribute_name")), qualifiedName(c.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
+	return change
 }
 
 // Change handles the case where the relationship and column match, but the grant does not
-func (c *GrantAttributeSchema) Change(obj interface{}) {
+func (c *GrantAttributeSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*GrantAttributeSchema)
 	if !ok {
-		fmt.Println("-- Error!!!, Change needs a GrantAttributeSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a GrantAttributeSchema instance", c2)
 	}
 
+	change := newChange("GRANT_ATTRIBUTE", ActionChange, c2.get("schema_name"), c.get("relationship_name")+"."+c.get("attribute_name"))
+
 	role, grants1 := parseGrants(c.get("attribute_acl"))
 	_, grants2 := parseGrants(c2.get("attribute_acl"))
 
@@ -183,8 +197,8 @@ func (c *GrantAttributeSchema) Change(obj interface{}) {
 		}
 	}
 	if len(grantList) > 0 {
-		fmt.Printf("GRANT %s (%s) ON %s.%s TO %s; -- Change\n", strings.Join(grantList, ", "),
-			c.get("attribute_name"), c2.get("schema_name"), c.get("relationship_name"), role)
+		change.addSql("GRANT %s (%s) ON %s TO %s", strings.Join(grantList, ", "),
+			quoteIdent(c.get("attribute_name")), qualifiedName(c2.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
 	}
 
 	// Find grants in the second db that are not in the first
@@ -196,42 +210,25 @@ func (c *GrantAttributeSchema) Change(obj interface{}) {
 		}
 	}
 	if len(revokeList) > 0 {
-		fmt.Printf("REVOKE %s (%s) ON %s.%s FROM %s; -- Change\n", strings.Join(revokeList, ", "), c.get("attribute_name"), c2.get("schema_name"), c.get("relationship_name"), role)
+		change.addSql("REVOKE %s (%s) ON %s FROM %s", strings.Join(revokeList, ", "), quoteIdent(c.get("attribute_name")), qualifiedName(c2.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
 	}
 
 	//fmt.Printf("--1 rel:%s, relAcl:%s, col:%s, colAcl:%s\n", c.get("attribute_name"), c.get("attribute_acl"), c.get("attribute_name"), c.get("attribute_acl"))
 	//fmt.Printf("--2 rel:%s, relAcl:%s, col:%s, colAcl:%s\n", c2.get("attribute_name"), c2.get("attribute_acl"), c2.get("attribute_name"), c2.get("attribute_acl"))
+	return change
 }
 
 // ==================================
 // Functions
 // ==================================
 
-// compareGrantAttributes outputs SQL to make the granted permissions match between DBs or schemas
-func compareGrantAttributes(conn1 *sql.DB, conn2 *sql.DB) {
+// compareGrantAttributes returns the changes needed to make the granted permissions match between DBs or schemas
+func compareGrantAttributes(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	buf1 := new(bytes.Buffer)
-	grantAttributeSqlTemplate.Execute(buf1, dbInfo1)
-
-	buf2 := new(bytes.Buffer)
-	grantAttributeSqlTemplate.Execute(buf2, dbInfo2)
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	rows1 := make(GrantAttributeRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := GrantAttributeRows(cat1.Query("GRANT_ATTRIBUTE", grantAttributeSqlTemplate))
 	sort.Sort(rows1)
-	//for _, row := range rows1 {
-		//fmt.Printf("--1b compare:%s, col:%s, colAcl:%s\n", row["compare_name"], row["attribute_name"], row["attribute_acl"])
-	//}
 
-	rows2 := make(GrantAttributeRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := GrantAttributeRows(cat2.Query("GRANT_ATTRIBUTE", grantAttributeSqlTemplate))
 	sort.Sort(rows2)
 	//for _, row := range rows2 {
 		//fmt.Printf("--2b compare:%s, col:%s, colAcl:%s\n", row["compare_name"], row["attribute_name"], row["attribute_acl"])
@@ -241,5 +238,5 @@ func compareGrantAttributes(conn1 *sql.DB, conn2 *sql.DB) {
 	var schema1 Schema = &GrantAttributeSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &GrantAttributeSchema{rows: rows2, rowNum: -1}
 
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
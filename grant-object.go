@@ -0,0 +1,346 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// grant-object.go compares the privileges on objects other than relationships and
+// their columns: schemas, functions, types, languages, foreign servers, and the
+// database itself.  Each of them is a schema type of its own (GRANT_SCHEMA,
+// GRANT_FUNCTION, etc.) but they share the GrantObjectSchema below.
+//
+
+package main
+
+import (
+	"fmt"
+	"github.com/joncrlsn/misc"
+	"os"
+	"sort"
+	"text/template"
+)
+
+var (
+	grantSchemaSqlTemplate = initGrantObjectSqlTemplate("GrantSchemaSqlTmpl", `
+    SELECT 'SCHEMA', n.nspname, NULL, NULL, NULL, NULL, NULL
+        , COALESCE(n.nspacl, pg_catalog.acldefault('n', n.nspowner)), n.nspowner
+    FROM pg_catalog.pg_namespace AS n
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_namespace" "n.oid"}}`)
+
+	grantFunctionSqlTemplate = initGrantObjectSqlTemplate("GrantFunctionSqlTmpl", `
+    SELECT CASE p.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END
+        , n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid)
+        , pg_catalog.oidvectortypes(p.proargtypes)
+        , CASE p.prokind WHEN 'a' THEN 'AGGREGATE' WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END
+        , l.lanname
+        , COALESCE(p.proacl, pg_catalog.acldefault('f', p.proowner)), p.proowner
+    FROM pg_catalog.pg_proc AS p
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = p.pronamespace)
+    INNER JOIN pg_catalog.pg_language AS l ON (l.oid = p.prolang)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_proc" "p.oid"}}`)
+
+	// Enums, composite types, domains, and range types (the same types as TYPE and OWNER)
+	grantTypeSqlTemplate = initGrantObjectSqlTemplate("GrantTypeSqlTmpl", `
+    SELECT CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END
+        , n.nspname, t.typname, NULL, NULL, NULL, NULL
+        , COALESCE(t.typacl, pg_catalog.acldefault('T', t.typowner)), t.typowner
+    FROM pg_catalog.pg_type AS t
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+    LEFT OUTER JOIN pg_catalog.pg_class AS c ON (c.oid = t.typrelid)
+    WHERE t.typtype IN ('e', 'c', 'd', 'r')
+    AND (t.typtype <> 'c' OR c.relkind = 'c')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_type" "t.oid"}}`)
+
+	// Privileges can only be granted on trusted languages.  Most languages are created
+	// by an extension (plpgsql too), but their privileges are still compared because
+	// CREATE EXTENSION does not restore them.
+	grantLanguageSqlTemplate = initGrantObjectSqlTemplate("GrantLanguageSqlTmpl", `
+    SELECT 'LANGUAGE', NULL, l.lanname, NULL, NULL, NULL, NULL
+        , COALESCE(l.lanacl, pg_catalog.acldefault('l', l.lanowner)), l.lanowner
+    FROM pg_catalog.pg_language AS l
+    WHERE l.lanpltrusted
+    AND l.lanispl`)
+
+	grantForeignServerSqlTemplate = initGrantObjectSqlTemplate("GrantForeignServerSqlTmpl", `
+    SELECT 'FOREIGN SERVER', NULL, s.srvname, NULL, NULL, NULL, NULL
+        , COALESCE(s.srvacl, pg_catalog.acldefault('S', s.srvowner)), s.srvowner
+    FROM pg_catalog.pg_foreign_server AS s
+    WHERE {{$.ExtensionFilter "pg_foreign_server" "s.oid"}}`)
+
+	// Only the database we are connected to.  Its name is left out of the compare_name
+	// because the two databases usually have different names.
+	grantDatabaseSqlTemplate = initGrantObjectSqlTemplate("GrantDatabaseSqlTmpl", `
+    SELECT 'DATABASE', NULL, NULL, NULL, NULL, NULL, NULL
+        , COALESCE(d.datacl, pg_catalog.acldefault('d', d.datdba)), d.datdba
+    FROM pg_catalog.pg_database AS d
+    WHERE d.datname = pg_catalog.current_database()`)
+)
+
+// Initializes the Sql template for one kind of object.  A NULL ACL means the object
+// has the default privileges (e.g. EXECUTE on a function for PUBLIC), so they are
+// compared as if they had been granted.  The owner's own privileges are left out
+// because they go with the owner, which OWNER compares.
+func initGrantObjectSqlTemplate(name string, objects string) *template.Template {
+	sql := `
+WITH objects (type, schema_name, object_name, arguments, arg_types, function_type, language, acl, owner) AS (` + objects + `
+)
+SELECT o.type || ':' || concat_ws('.', {{if eq $.DbSchema "*" }}o.schema_name, {{end}}o.object_name)
+        || COALESCE('(' || o.arg_types || ')', '') AS compare_name
+    , o.type
+    , o.schema_name
+    , o.object_name
+    , o.arguments
+    , o.arg_types
+    , o.function_type
+    , o.language
+    , g.acl AS object_acl
+FROM objects AS o, unnest(o.acl) AS g(acl)
+WHERE (SELECT x.grantee FROM pg_catalog.aclexplode(ARRAY[g.acl]) AS x LIMIT 1) <> o.owner;
+`
+
+	t := template.New(name)
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// GrantObjectRows definition
+// ==================================
+
+// GrantObjectRows is a sortable slice of string maps
+type GrantObjectRows []map[string]string
+
+func (slice GrantObjectRows) Len() int {
+	return len(slice)
+}
+
+func (slice GrantObjectRows) Less(i, j int) bool {
+	if slice[i]["compare_name"] != slice[j]["compare_name"] {
+		return slice[i]["compare_name"] < slice[j]["compare_name"]
+	}
+
+	// Only compare the role part of the ACL
+	role1, _ := parseAcl(slice[i]["object_acl"])
+	role2, _ := parseAcl(slice[j]["object_acl"])
+	return role1 < role2
+}
+
+func (slice GrantObjectRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// GrantObjectSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// GrantObjectSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.  The kind is the schema
+// type the rows are for (e.g. GRANT_SCHEMA).
+type GrantObjectSchema struct {
+	kind   string
+	rows   GrantObjectRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *GrantObjectSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *GrantObjectSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *GrantObjectSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*GrantObjectSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a GrantObjectSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	if val != 0 {
+		return val
+	}
+
+	role1, _ := parseAcl(c.get("object_acl"))
+	role2, _ := parseAcl(c2.get("object_acl"))
+	return misc.CompareStrings(role1, role2)
+}
+
+// ObjectKey identifies the object the grant is on in the current row when ordering by dependency
+func (c *GrantObjectSchema) ObjectKey() string {
+	switch c.get("type") {
+	case "SCHEMA":
+		return "schema:" + c.get("schema_name")
+	case "FUNCTION", "PROCEDURE":
+		return "function:" + c.get("schema_name") + "." + c.get("object_name") + "(" + c.get("arg_types") + ")"
+	case "TYPE", "DOMAIN":
+		return "type:" + c.get("schema_name") + "." + c.get("object_name")
+	case "LANGUAGE":
+		return "language:" + c.get("object_name")
+	case "FOREIGN SERVER":
+		return "foreign_server:" + c.get("object_name")
+	}
+	return "database"
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *GrantObjectSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// schema returns the schema of the object in db2
+func (c *GrantObjectSchema) schema() string {
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		return dbInfo2.DbSchema
+	}
+	return c.get("schema_name")
+}
+
+// newChange returns an empty change named for the object in the given schema
+func (c *GrantObjectSchema) newChange(action string, schema string) *SchemaChange {
+	switch c.get("type") {
+	case "SCHEMA":
+		return newChange(c.kind, action, "", schema)
+	case "LANGUAGE", "FOREIGN SERVER":
+		return newChange(c.kind, action, "", c.get("object_name"))
+	case "DATABASE":
+		return newChange(c.kind, action, "", dbInfo2.DbName)
+	}
+	name := c.get("object_name")
+	if c.get("arg_types") != "null" && len(c.get("arg_types")) > 0 {
+		name += "(" + c.get("arg_types") + ")"
+	}
+	return newChange(c.kind, action, schema, name)
+}
+
+// target returns what the privileges are granted on for the object in the given
+// schema, e.g. FUNCTION s1.total(a integer)
+func (c *GrantObjectSchema) target(schema string) string {
+	switch c.get("type") {
+	case "SCHEMA":
+		return "SCHEMA " + quoteIdent(schema)
+	case "FUNCTION", "PROCEDURE":
+		return c.get("type") + " " + qualifiedName(schema, c.get("object_name")) + "(" + c.get("arguments") + ")"
+	case "TYPE", "DOMAIN":
+		return c.get("type") + " " + qualifiedName(schema, c.get("object_name"))
+	case "DATABASE":
+		// Privileges are always changed on the second database
+		return "DATABASE " + quoteIdent(dbInfo2.DbName)
+	}
+	return c.get("type") + " " + quoteIdent(c.get("object_name"))
+}
+
+// Add returns SQL to add the grant
+func (c *GrantObjectSchema) Add() *SchemaChange {
+	schema := c.schema()
+	change := c.newChange(ActionAdd, schema)
+
+	role, grants := parseAclGrants(c.get("object_acl"))
+	addGrantSql(change, "", c.target(schema), quoteIdent(role), grants, aclGrants{})
+	return change
+}
+
+// Drop returns SQL to drop the grant
+func (c *GrantObjectSchema) Drop() *SchemaChange {
+	schema := c.get("schema_name")
+	change := c.newChange(ActionDrop, schema)
+
+	role, grants := parseAclGrants(c.get("object_acl"))
+	addGrantSql(change, "", c.target(schema), quoteIdent(role), aclGrants{}, grants)
+	return change
+}
+
+// Change handles the case where the object and role match, but the grant does not
+func (c *GrantObjectSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*GrantObjectSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a GrantObjectSchema instance", c2)
+	}
+
+	schema := c2.schema()
+	change := c.newChange(ActionChange, schema)
+
+	role, grants1 := parseAclGrants(c.get("object_acl"))
+	_, grants2 := parseAclGrants(c2.get("object_acl"))
+	addGrantSql(change, "", c.target(schema), quoteIdent(role), grants1, grants2)
+	return change
+}
+
+// ==================================
+// Functions
+// ==================================
+
+// compareGrantObjects returns the changes needed to make the privileges on one kind
+// of object match between DBs or schemas
+func compareGrantObjects(kind string, rows1 GrantObjectRows, rows2 GrantObjectRows) []*SchemaChange {
+	sort.Sort(rows1)
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here for some unknown reason
+	var schema1 Schema = &GrantObjectSchema{kind: kind, rows: rows1, rowNum: -1}
+	var schema2 Schema = &GrantObjectSchema{kind: kind, rows: rows2, rowNum: -1}
+
+	return doDiff(schema1, schema2)
+}
+
+// compareGrantSchemas compares the USAGE and CREATE privileges on schemas
+func compareGrantSchemas(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_SCHEMA",
+		GrantObjectRows(cat1.Query("GRANT_SCHEMA", grantSchemaSqlTemplate)),
+		GrantObjectRows(cat2.Query("GRANT_SCHEMA", grantSchemaSqlTemplate)))
+}
+
+// compareGrantFunctions compares the EXECUTE privilege on functions, procedures, and
+// aggregates in the languages given by --languages
+func compareGrantFunctions(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_FUNCTION",
+		GrantObjectRows(filterLanguages(cat1.Query("GRANT_FUNCTION", grantFunctionSqlTemplate))),
+		GrantObjectRows(filterLanguages(cat2.Query("GRANT_FUNCTION", grantFunctionSqlTemplate))))
+}
+
+// compareGrantTypes compares the USAGE privilege on types and domains
+func compareGrantTypes(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_TYPE",
+		GrantObjectRows(cat1.Query("GRANT_TYPE", grantTypeSqlTemplate)),
+		GrantObjectRows(cat2.Query("GRANT_TYPE", grantTypeSqlTemplate)))
+}
+
+// compareGrantLanguages compares the USAGE privilege on procedural languages
+func compareGrantLanguages(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_LANGUAGE",
+		GrantObjectRows(cat1.Query("GRANT_LANGUAGE", grantLanguageSqlTemplate)),
+		GrantObjectRows(cat2.Query("GRANT_LANGUAGE", grantLanguageSqlTemplate)))
+}
+
+// compareGrantForeignServers compares the USAGE privilege on foreign servers
+func compareGrantForeignServers(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_FOREIGN_SERVER",
+		GrantObjectRows(cat1.Query("GRANT_FOREIGN_SERVER", grantForeignServerSqlTemplate)),
+		GrantObjectRows(cat2.Query("GRANT_FOREIGN_SERVER", grantForeignServerSqlTemplate)))
+}
+
+// compareGrantDatabases compares the CONNECT, CREATE, and TEMPORARY privileges on
+// the two databases
+func compareGrantDatabases(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return compareGrantObjects("GRANT_DATABASE",
+		GrantObjectRows(cat1.Query("GRANT_DATABASE", grantDatabaseSqlTemplate)),
+		GrantObjectRows(cat2.Query("GRANT_DATABASE", grantDatabaseSqlTemplate)))
+}
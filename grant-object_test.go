@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_GrantObjectSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	dbInfo2.DbName = "app_dev"
+	row := func(objectType, schema, name, arguments, argTypes, acl string) map[string]string {
+		return map[string]string{"type": objectType, "schema_name": schema, "object_name": name,
+			"arguments": arguments, "arg_types": argTypes, "object_acl": acl}
+	}
+	tests := []struct {
+		row      map[string]string
+		expected string
+	}{
+		{row("SCHEMA", "s1", "null", "null", "null", "app=UC/postgres"), "GRANT CREATE, USAGE ON SCHEMA s1 TO app"},
+		{row("FUNCTION", "s1", "total", "a integer", "integer", "=X/postgres"), "GRANT EXECUTE ON FUNCTION s1.total(a integer) TO public"},
+		{row("PROCEDURE", "s1", "archive", "", "", "app=X/postgres"), "GRANT EXECUTE ON PROCEDURE s1.archive() TO app"},
+		{row("DOMAIN", "s1", "email", "null", "null", `"Report Reader"=U/postgres`), `GRANT USAGE ON DOMAIN s1.email TO "Report Reader"`},
+		{row("LANGUAGE", "null", "plpgsql", "null", "null", "app=U/postgres"), "GRANT USAGE ON LANGUAGE plpgsql TO app"},
+		{row("FOREIGN SERVER", "null", "remote", "null", "null", "app=U/postgres"), "GRANT USAGE ON FOREIGN SERVER remote TO app"},
+		{row("DATABASE", "null", "null", "null", "null", "app=CTc/postgres"), "GRANT CONNECT, CREATE, TEMPORARY ON DATABASE app_dev TO app"},
+	}
+	for _, test := range tests {
+		c := &GrantObjectSchema{kind: "GRANT_TEST", rows: GrantObjectRows{test.row}, rowNum: 0}
+		expectStatements(t, c.Add(), test.expected)
+	}
+
+	c1 := &GrantObjectSchema{kind: "GRANT_DATABASE", rows: GrantObjectRows{row("DATABASE", "null", "null", "null", "null", "=Tc/postgres")}, rowNum: 0}
+	c2 := &GrantObjectSchema{kind: "GRANT_DATABASE", rows: GrantObjectRows{row("DATABASE", "null", "null", "null", "null", "=CT/postgres")}, rowNum: 0}
+	change := c1.Change(c2)
+	expectStatements(t, change, "GRANT CONNECT ON DATABASE app_dev TO public", "REVOKE CREATE ON DATABASE app_dev FROM public")
+	if change.Name != "app_dev" {
+		t.Errorf("Wrong name: %s", change.Name)
+	}
+
+	c1 = &GrantObjectSchema{kind: "GRANT_FUNCTION", rows: GrantObjectRows{row("FUNCTION", "s1", "total", "a integer", "integer", "app=X/postgres")}, rowNum: 0}
+	change = c1.Drop()
+	expectStatements(t, change, "REVOKE EXECUTE ON FUNCTION s1.total(a integer) FROM app")
+	if change.Name != "s1.total(integer)" || c1.ObjectKey() != "function:s1.total(integer)" {
+		t.Errorf("Wrong name or key: %s %s", change.Name, c1.ObjectKey())
+	}
+
+	// Privileges with a grant option (*)
+	c1 = &GrantObjectSchema{kind: "GRANT_FUNCTION", rows: GrantObjectRows{row("FUNCTION", "s1", "total", "a integer", "integer", "bob=X*/alice")}, rowNum: 0}
+	expectStatements(t, c1.Add(), "GRANT EXECUTE ON FUNCTION s1.total(a integer) TO bob WITH GRANT OPTION")
+	expectStatements(t, c1.Drop(), "REVOKE EXECUTE ON FUNCTION s1.total(a integer) FROM bob")
+	c2 = &GrantObjectSchema{kind: "GRANT_FUNCTION", rows: GrantObjectRows{row("FUNCTION", "s1", "total", "a integer", "integer", "bob=X/alice")}, rowNum: 0}
+	expectStatements(t, c1.Change(c2), "GRANT EXECUTE ON FUNCTION s1.total(a integer) TO bob WITH GRANT OPTION")
+	expectStatements(t, c2.Change(c1), "REVOKE GRANT OPTION FOR EXECUTE ON FUNCTION s1.total(a integer) FROM bob")
+
+	c1 = &GrantObjectSchema{kind: "GRANT_SCHEMA", rows: GrantObjectRows{row("SCHEMA", "s1", "null", "null", "null", "bob=U*C/alice")}, rowNum: 0}
+	expectStatements(t, c1.Add(), "GRANT CREATE ON SCHEMA s1 TO bob", "GRANT USAGE ON SCHEMA s1 TO bob WITH GRANT OPTION")
+	c2 = &GrantObjectSchema{kind: "GRANT_SCHEMA", rows: GrantObjectRows{row("SCHEMA", "s1", "null", "null", "null", "bob=C*/alice")}, rowNum: 0}
+	expectStatements(t, c1.Change(c2),
+		"GRANT USAGE ON SCHEMA s1 TO bob WITH GRANT OPTION",
+		"REVOKE GRANT OPTION FOR CREATE ON SCHEMA s1 FROM bob")
+}
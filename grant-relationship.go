@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -28,6 +26,7 @@ SELECT n.nspname AS schema_name
   , {{ if eq $.DbSchema "*" }}n.nspname::text  || '.' || {{ end }}c.relkind::text  || '.' || c.relname::text  AS compare_name
   , CASE c.relkind
     WHEN 'r' THEN 'TABLE'
+    WHEN 'p' THEN 'TABLE'
     WHEN 'v' THEN 'VIEW'
     WHEN 'S' THEN 'SEQUENCE'
     WHEN 'f' THEN 'FOREIGN TABLE'
@@ -36,14 +35,10 @@ SELECT n.nspname AS schema_name
   , unnest(c.relacl) AS relationship_acl
 FROM pg_catalog.pg_class c
 LEFT JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
-WHERE c.relkind IN ('r', 'v', 'S', 'f')
+WHERE c.relkind IN ('r', 'p', 'v', 'S', 'f')
 --AND pg_catalog.pg_table_is_visible(c.oid)
-{{ if eq $.DbSchema "*" }}
-AND n.nspname NOT LIKE 'pg_%'
-AND n.nspname <> 'information_schema'
-{{ else }}
-AND n.nspname = '{{ $.DbSchema }}'
-{{ end }};
+AND {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}};
 `
 
 	t := template.New("GrantRelationshipSqlTmpl")
@@ -125,7 +120,7 @@ func (c *GrantRelationshipSchema) NextRow() bool {
 func (c *GrantRelationshipSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*GrantRelationshipSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare needs a GrantRelationshipSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a GrantRelationshipSchema instance", c2)
 		return +999
 	}
 
@@ -140,30 +135,49 @@ func (c *GrantRelationshipSchema) Compare(obj interface{}) int {
 	return val
 }
 
-// Add prints SQL to add the grant
-func (c *GrantRelationshipSchema) Add() {
+// ObjectKey identifies the relationship the grant is on in the current row when ordering by dependency
+func (c *GrantRelationshipSchema) ObjectKey() string {
+	return relationKey(c.get("type"), c.get("schema_name"), c.get("relationship_name"))
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *GrantRelationshipSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to add the grant
+func (c *GrantRelationshipSchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("schema_name")
 	}
+	change := newChange("GRANT_RELATIONSHIP", ActionAdd, schema, c.get("relationship_name"))
 
 	role, grants := parseGrants(c.get("relationship_acl"))
-	fmt.Printf("GRANT %s ON %s.%s TO %s; -- Add\n", strings.Join(grants, ", "), schema, c.get("relationship_name"), role)
+	change.addSql("GRANT %s ON %s TO %s", strings.Join(grants, ", "), qualifiedName(schema, c.get("relationship_name")), quoteIdent(role))
+	return change
 }
 
-// Drop prints SQL to drop the grant
-func (c *GrantRelationshipSchema) Drop() {
+// Drop returns SQL to drop the grant
+func (c *GrantRelationshipSchema) Drop() *SchemaChange {
+	change := newChange("GRANT_RELATIONSHIP", ActionDrop, c.get("schema_name"), c.get("relationship_name"))
 	role, grants := parseGrants(c.get("relationship_acl"))
-	fmt.Printf("REVOKE %s ON %s.%s FROM %s; -- Drop\n", strings.Join(grants, ", "), c.get("schema_name"), c.get("relationship_name"), role)
+	change.addSql("REVOKE %s ON %s FROM %s", strings.Join(grants, ", "), qualifiedName(c.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
+	return change
 }
 
 // Change handles the case where the relationship and column match, but the grant does not
-func (c *GrantRelationshipSchema) Change(obj interface{}) {
+func (c *GrantRelationshipSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*GrantRelationshipSchema)
 	if !ok {
-		fmt.Println("-- Error!!!, Change needs a GrantRelationshipSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a GrantRelationshipSchema instance", c2)
 	}
 
+	change := newChange("GRANT_RELATIONSHIP", ActionChange, c2.get("schema_name"), c.get("relationship_name"))
+
 	role, grants1 := parseGrants(c.get("relationship_acl"))
 	_, grants2 := parseGrants(c2.get("relationship_acl"))
 
@@ -176,7 +190,7 @@ func (c *GrantRelationshipSchema) Change(obj interface{}) {
 		}
 	}
 	if len(grantList) > 0 {
-		fmt.Printf("GRANT %s ON %s.%s TO %s; -- Change\n", strings.Join(grantList, ", "), c2.get("schema_name"), c.get("relationship_name"), role)
+		change.addSql("GRANT %s ON %s TO %s", strings.Join(grantList, ", "), qualifiedName(c2.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
 	}
 
 	// Find grants in the second db that are not in the first
@@ -188,44 +202,30 @@ func (c *GrantRelationshipSchema) Change(obj interface{}) {
 		}
 	}
 	if len(revokeList) > 0 {
-		fmt.Printf("REVOKE %s ON %s.%s FROM %s; -- Change\n", strings.Join(revokeList, ", "), c2.get("schema_name"), c.get("relationship_name"), role)
+		change.addSql("REVOKE %s ON %s FROM %s", strings.Join(revokeList, ", "), qualifiedName(c2.get("schema_name"), c.get("relationship_name")), quoteIdent(role))
 	}
 
 	//	fmt.Printf("--1 rel:%s, relAcl:%s, col:%s, colAcl:%s\n", c.get("relationship_name"), c.get("relationship_acl"), c.get("column_name"), c.get("column_acl"))
 	//	fmt.Printf("--2 rel:%s, relAcl:%s, col:%s, colAcl:%s\n", c2.get("relationship_name"), c2.get("relationship_acl"), c2.get("column_name"), c2.get("column_acl"))
+	return change
 }
 
 // ==================================
 // Functions
 // ==================================
 
-// compareGrantRelationships outputs SQL to make the granted permissions match between DBs or schemas
-func compareGrantRelationships(conn1 *sql.DB, conn2 *sql.DB) {
-
-	buf1 := new(bytes.Buffer)
-	grantRelationshipSqlTemplate.Execute(buf1, dbInfo1)
-
-	buf2 := new(bytes.Buffer)
-	grantRelationshipSqlTemplate.Execute(buf2, dbInfo2)
+// compareGrantRelationships returns the changes needed to make the granted permissions match between DBs or schemas
+func compareGrantRelationships(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	rows1 := make(GrantRelationshipRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := GrantRelationshipRows(cat1.Query("GRANT_RELATIONSHIP", grantRelationshipSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(GrantRelationshipRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := GrantRelationshipRows(cat2.Query("GRANT_RELATIONSHIP", grantRelationshipSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here for some unknown (to me) reason
 	var schema1 Schema = &GrantRelationshipSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &GrantRelationshipSchema{rows: rows2, rowNum: -1}
 
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
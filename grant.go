@@ -13,9 +13,12 @@ import (
  		"fmt"
  		"strings"
  		"regexp"
+ 		"os"
+ 		"github.com/joncrlsn/misc"
 )
 
-var aclRegex = regexp.MustCompile(`([a-zA-Z0-9_]+)*=([rwadDxtXUCcT]+)/([a-zA-Z0-9_]+)$`)
+// The role names in an ACL are double-quoted when they have special characters
+var aclRegex = regexp.MustCompile(`^((?:[^"=]|"(?:[^"]|"")*")*)=([rwadDxtXUCcTm*]+)/(.+)$`)
 
 var permMap = map[string]string{
 	"a": "INSERT",
@@ -30,10 +33,12 @@ var permMap = map[string]string{
 	"C": "CREATE",
 	"c": "CONNECT",
 	"T": "TEMPORARY",
+	"m": "MAINTAIN",
 }
 
 /*
-parseGrants converts an ACL (access control list) line into a role and a slice of permission strings
+parseGrants converts an ACL (access control list) line into a role and a slice of permission strings.
+The grant options (*) are left out; see parseAclGrants.
 
 Example of an ACL: user1=rwa/c42
 
@@ -51,6 +56,7 @@ rolename=xxxx -- privileges granted to a role
             C -- CREATE
             c -- CONNECT
             T -- TEMPORARY
+            m -- MAINTAIN (Postgres 17)
       arwdDxt -- ALL PRIVILEGES (for tables, varies for other objects)
             * -- grant option for preceding privilege
         /yyyy -- role that granted this privilege
@@ -65,23 +71,78 @@ func parseGrants(acl string) (string, []string) {
 	permWords := make(sort.StringSlice, 0)
 	for _, c := range strings.Split(perms, "") {
 		permWord := permMap[c]
-		if len(permWord) > 0 {
+		if c == "*" {
+			continue
+		} else if len(permWord) > 0 {
 			permWords = append(permWords, permWord)
 		} else {
-			fmt.Printf("-- Error, found permission character we haven't coded for: %s", c)
+			fmt.Fprintf(os.Stderr, "Error, found permission character we haven't coded for: %s\n", c)
 		}
 	}
 	permWords.Sort()
 	return role, permWords
 }
 
+// aclGrants are the privileges an ACL entry grants to a role, and the ones among them
+// that the role may grant to others (those followed by * in the ACL)
+type aclGrants struct {
+	privileges []string
+	options    []string
+}
+
+// parseAclGrants converts an ACL line into a role and the privileges granted to it,
+// with their grant options
+func parseAclGrants(acl string) (string, aclGrants) {
+	role, privileges := parseGrants(acl)
+	_, perms := parseAcl(acl)
+	options := make(sort.StringSlice, 0)
+	for i := 1; i < len(perms); i++ {
+		if perms[i] == '*' && len(permMap[perms[i-1:i]]) > 0 {
+			options = append(options, permMap[perms[i-1:i]])
+		}
+	}
+	options.Sort()
+	return role, aclGrants{privileges: privileges, options: options}
+}
+
+// addGrantSql adds the GRANT and REVOKE statements that change the privileges of the
+// grantee (already quoted) on the target from the have privileges to the want
+// privileges.  Each statement starts with the prefix (for ALTER DEFAULT PRIVILEGES).
+func addGrantSql(change *SchemaChange, prefix string, target string, grantee string, want aclGrants, have aclGrants) {
+	missing := func(list []string, from []string) []string {
+		var result []string
+		for _, g := range list {
+			if !misc.ContainsString(from, g) {
+				result = append(result, g)
+			}
+		}
+		return result
+	}
+
+	// The privileges that need a grant option are granted with it
+	if grantList := missing(missing(want.privileges, have.privileges), want.options); len(grantList) > 0 {
+		change.addSql("%sGRANT %s ON %s TO %s", prefix, strings.Join(grantList, ", "), target, grantee)
+	}
+	if optionList := missing(want.options, have.options); len(optionList) > 0 {
+		change.addSql("%sGRANT %s ON %s TO %s WITH GRANT OPTION", prefix, strings.Join(optionList, ", "), target, grantee)
+	}
+
+	// Revoking a privilege revokes its grant option too
+	if optionList := missing(missing(have.options, want.options), missing(have.privileges, want.privileges)); len(optionList) > 0 {
+		change.addSql("%sREVOKE GRANT OPTION FOR %s ON %s FROM %s", prefix, strings.Join(optionList, ", "), target, grantee)
+	}
+	if revokeList := missing(have.privileges, want.privileges); len(revokeList) > 0 {
+		change.addSql("%sREVOKE %s ON %s FROM %s", prefix, strings.Join(revokeList, ", "), target, grantee)
+	}
+}
+
 // parseAcl parses an ACL (access control list) string (e.g. 'c42=aur/postgres') into a role and
 // a string made up of one-character permissions
 func parseAcl(acl string) (role string, perms string) {
 	role, perms = "", ""
 	matches := aclRegex.FindStringSubmatch(acl)
 	if matches != nil {
-		role = matches[1]
+		role = unquoteAclRole(matches[1])
 		perms = matches[2]
 		if len(role) == 0 {
 			role = "public"
@@ -89,3 +150,11 @@ func parseAcl(acl string) (role string, perms string) {
 	}
 	return role, perms
 }
+
+// unquoteAclRole removes the double quotes around a role name in an ACL
+func unquoteAclRole(role string) string {
+	if strings.HasPrefix(role, `"`) && strings.HasSuffix(role, `"`) && len(role) > 1 {
+		return strings.Replace(role[1:len(role)-1], `""`, `"`, -1)
+	}
+	return role
+}
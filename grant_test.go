@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -11,6 +12,18 @@ func Test_parseAcls(t *testing.T) {
 	doParseAcls(t, "u3=rwad/postgres", "u3", 4) // second of two lines
 	doParseAcls(t, "user2=arwxt/postgres", "user2", 5)
 	doParseAcls(t, "", "", 0)
+	doParseAcls(t, "bob=X*/alice", "bob", 2)
+}
+
+func Test_parseGrantOptions(t *testing.T) {
+	role, perms := parseGrants("bob=r*wa*/alice")
+	if role != "bob" || strings.Join(perms, ", ") != "INSERT, SELECT, UPDATE" {
+		t.Errorf("Wrong grants parsed: %s %q", role, perms)
+	}
+	role, grants := parseAclGrants("bob=r*wa*/alice")
+	if role != "bob" || strings.Join(grants.privileges, ", ") != "INSERT, SELECT, UPDATE" || strings.Join(grants.options, ", ") != "INSERT, SELECT" {
+		t.Errorf("Wrong grants parsed: %s %+v", role, grants)
+	}
 }
 
 func doParseAcls(t *testing.T, acl string, expectedRole string, expectedPermCount int) {
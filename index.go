@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -33,19 +31,22 @@ SELECT {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}c.relname || '.' || c
     , pg_catalog.pg_get_indexdef(i.indexrelid, 0, true) AS index_def
     , pg_catalog.pg_get_constraintdef(con.oid, true) AS constraint_def
     , con.contype AS typ
+    , c.relkind = 'p' AS partitioned
+    -- The index of the partitioned table that this index of a partition belongs to
+    , pn.nspname AS parent_index_schema
+    , pc.relname AS parent_index
 FROM pg_catalog.pg_index AS i
 INNER JOIN pg_catalog.pg_class AS c ON (c.oid = i.indrelid)
 INNER JOIN pg_catalog.pg_class AS c2 ON (c2.oid = i.indexrelid)
 LEFT OUTER JOIN pg_catalog.pg_constraint con
     ON (con.conrelid = i.indrelid AND con.conindid = i.indexrelid AND con.contype IN ('p','u','x'))
 INNER JOIN pg_catalog.pg_namespace AS n ON (c2.relnamespace = n.oid)
+LEFT OUTER JOIN pg_catalog.pg_inherits AS ih ON (ih.inhrelid = c2.oid)
+LEFT OUTER JOIN pg_catalog.pg_class AS pc ON (pc.oid = ih.inhparent)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS pn ON (pn.oid = pc.relnamespace)
 WHERE true
-{{if eq $.DbSchema "*"}}
-AND n.nspname NOT LIKE 'pg_%' 
-AND n.nspname <> 'information_schema' 
-{{else}}
-AND n.nspname = '{{$.DbSchema}}'
-{{end}}
+AND {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
 `
 	t := template.New("IndexSqlTmpl")
 	template.Must(t.Parse(sql))
@@ -115,29 +116,57 @@ func (c *IndexSchema) NextRow() bool {
 func (c *IndexSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*IndexSchema)
 	if !ok {
-		fmt.Println("Error!!!, change needs a IndexSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, change needs a IndexSchema instance", c2)
 		return +999
 	}
 
-	if len(c.get("table_name")) == 0 || len(c.get("index_name")) == 0 {
-		fmt.Printf("--Comparing (table_name and/or index_name is empty): %v\n", c.getRow())
-		fmt.Printf("--           %v\n", c2.getRow())
-	}
-
 	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
 	return val
 }
 
-// Add prints SQL to add the index
-func (c *IndexSchema) Add() {
+// ObjectKey identifies the index in the current row when ordering by dependency
+func (c *IndexSchema) ObjectKey() string {
+	return "index:" + c.get("schema_name") + "." + c.get("index_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *IndexSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to add the index
+func (c *IndexSchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("schema_name")
 	}
+	change := newChange("INDEX", ActionAdd, schema, c.get("index_name"))
+	c.addCreate(change, schema)
+	if isChildIndex(c.getRow()) {
+		// Make it the index of the partition for the partitioned table's index
+		parentSchema := schema
+		if c.get("parent_index_schema") != c.get("schema_name") {
+			parentSchema = c.get("parent_index_schema")
+		}
+		change.addSql("ALTER INDEX %s ATTACH PARTITION %s", qualifiedName(parentSchema, c.get("parent_index")), qualifiedName(schema, c.get("index_name")))
+	}
+	return change
+}
+
+// isChildIndex tells you whether the index row is for an index of a partition that
+// belongs to an index of the partitioned table
+func isChildIndex(row map[string]string) bool {
+	return row["parent_index"] != "null" && len(row["parent_index"]) > 0
+}
 
+// addCreate adds the SQL to create the index (and its constraint) in the given schema
+func (c *IndexSchema) addCreate(change *SchemaChange, schema string) {
 	// Assertion
 	if c.get("index_def") == "null" || len(c.get("index_def")) == 0 {
-		fmt.Printf("-- Add Unexpected situation in index.go: there is no index_def for %s.%s %s\n", schema, c.get("table_name"), c.get("index_name"))
+		change.addWarning("Add Unexpected situation in index.go: there is no index_def for %s.%s %s", schema, c.get("table_name"), c.get("index_name"))
 		return
 	}
 
@@ -147,60 +176,84 @@ func (c *IndexSchema) Add() {
 	if dbInfo1.DbSchema != dbInfo2.DbSchema {
 		indexDef = strings.Replace(
 			indexDef,
-			fmt.Sprintf(" %s.%s ", c.get("schema_name"), c.get("table_name")),
-			fmt.Sprintf(" %s.%s ", dbInfo2.DbSchema, c.get("table_name")),
+			" "+qualifiedName(c.get("schema_name"), c.get("table_name"))+" ",
+			" "+qualifiedName(dbInfo2.DbSchema, c.get("table_name"))+" ",
 			-1)
 	}
 
-	fmt.Printf("%v;\n", indexDef)
+	// An index of a partitioned table is created ON ONLY the table when it is read
+	// from the catalog.  Without ONLY it is created on the partitions too.
+	indexDef = strings.Replace(indexDef, " ON ONLY ", " ON ", 1)
+
+	if c.get("partitioned") == "true" && c.get("constraint_def") != "null" {
+		// The primary key or unique constraint of a partitioned table can't be added
+		// using an existing index, so the constraint creates the index
+		change.addSql("ALTER TABLE %s ADD CONSTRAINT %s %s", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("index_name")), c.get("constraint_def"))
+		return
+	}
+
+	change.addSql("%v", indexDef)
 
 	if c.get("constraint_def") != "null" {
 		// Create the constraint using the index we just created
 		if c.get("pk") == "true" {
 			// Add primary key using the index
-			fmt.Printf("ALTER TABLE %s.%s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s; -- (1)\n", schema, c.get("table_name"), c.get("index_name"), c.get("index_name"))
+			change.addSql("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("index_name")), quoteIdent(c.get("index_name")))
 		} else if c.get("uq") == "true" {
 			// Add unique constraint using the index
-			fmt.Printf("ALTER TABLE %s.%s ADD CONSTRAINT %s UNIQUE USING INDEX %s; -- (2)\n", schema, c.get("table_name"), c.get("index_name"), c.get("index_name"))
+			change.addSql("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE USING INDEX %s", qualifiedName(schema, c.get("table_name")), quoteIdent(c.get("index_name")), quoteIdent(c.get("index_name")))
 		}
 	}
 }
 
-// Drop prints SQL to drop the index
-func (c *IndexSchema) Drop() {
+// Drop returns SQL to drop the index
+func (c *IndexSchema) Drop() *SchemaChange {
+	change := newChange("INDEX", ActionDrop, c.get("schema_name"), c.get("index_name"))
+	change.Destructive = true
+	c.addDrop(change)
+	return change
+}
+
+// addDrop adds the SQL to drop the index (and its constraint)
+func (c *IndexSchema) addDrop(change *SchemaChange) {
 	if c.get("constraint_def") != "null" {
-		fmt.Println("-- Warning, this may drop foreign keys pointing at this column.  Make sure you re-run the FOREIGN_KEY diff after running this SQL.")
-		fmt.Printf("ALTER TABLE %s.%s DROP CONSTRAINT %s CASCADE; -- %s\n", c.get("schema_name"), c.get("table_name"), c.get("index_name"), c.get("constraint_def"))
+		change.addWarning("Warning, this may drop foreign keys pointing at this column.  Make sure you re-run the FOREIGN_KEY diff after running this SQL.")
+		change.addWarning("Dropping %s", c.get("constraint_def"))
+		change.addSql("ALTER TABLE %s DROP CONSTRAINT %s CASCADE", qualifiedName(c.get("schema_name"), c.get("table_name")), quoteIdent(c.get("index_name")))
 	}
-	fmt.Printf("DROP INDEX %s.%s;\n", c.get("schema_name"), c.get("index_name"))
+	change.addSql("DROP INDEX %s", qualifiedName(c.get("schema_name"), c.get("index_name")))
 }
 
 // Change handles the case where the table and column match, but the details do not
-func (c *IndexSchema) Change(obj interface{}) {
+func (c *IndexSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*IndexSchema)
 	if !ok {
-		fmt.Println("-- Error!!!, Change needs an IndexSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs an IndexSchema instance", c2)
 	}
+	change := newChange("INDEX", ActionChange, c2.get("schema_name"), c.get("index_name"))
 
 	// Table and constraint name matches... We need to make sure the details match
 
 	// NOTE that there should always be an index_def for both c and c2 (but we're checking below anyway)
 	if len(c.get("index_def")) == 0 {
-		fmt.Printf("-- Change: Unexpected situation in index.go: index_def is empty for 1: %v  2:%v\n", c.getRow(), c2.getRow())
-		return
+		change.addWarning("Change: Unexpected situation in index.go: index_def is empty for 1: %v  2:%v", c.getRow(), c2.getRow())
+		return change
 	}
 	if len(c2.get("index_def")) == 0 {
-		fmt.Printf("-- Change: Unexpected situation in index.go: index_def is empty for 2: %v 1: %v\n", c2.getRow(), c.getRow())
-		return
+		change.addWarning("Change: Unexpected situation in index.go: index_def is empty for 2: %v 1: %v", c2.getRow(), c.getRow())
+		return change
 	}
 
 	if c.get("constraint_def") != c2.get("constraint_def") {
 		// c1.constraint and c2.constraint are just different
-		fmt.Printf("-- CHANGE: Different defs on %s:\n--    %s\n--    %s\n", c.get("table_name"), c.get("constraint_def"), c2.get("constraint_def"))
+		change.addWarning("CHANGE: Different defs on %s:", c.get("table_name"))
+		change.addWarning("   %s", c.get("constraint_def"))
+		change.addWarning("   %s", c2.get("constraint_def"))
 		if c.get("constraint_def") == "null" {
 			// c1.constraint does not exist, c2.constraint does, so
 			// Drop constraint
-			fmt.Printf("DROP INDEX %s; -- %s \n", c2.get("index_name"), c2.get("index_def"))
+			change.addWarning("Dropping %s", c2.get("index_def"))
+			change.addSql("DROP INDEX %s", qualifiedName(c2.get("schema_name"), c2.get("index_name")))
 		} else if c2.get("constraint_def") == "null" {
 			// c1.constraint exists, c2.constraint does not, so
 			// Add constraint
@@ -209,16 +262,17 @@ func (c *IndexSchema) Change(obj interface{}) {
 				// Add constraint using the index
 				if c.get("pk") == "true" {
 					// Add primary key using the index
-					fmt.Printf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s; -- (3)\n", c.get("table_name"), c.get("index_name"), c.get("index_name"))
+					change.addSql("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s", qualifiedName(c2.get("schema_name"), c2.get("table_name")), quoteIdent(c.get("index_name")), quoteIdent(c.get("index_name")))
 				} else if c.get("uq") == "true" {
 					// Add unique constraint using the index
-					fmt.Printf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE USING INDEX %s; -- (4)\n", c.get("table_name"), c.get("index_name"), c.get("index_name"))
+					change.addSql("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE USING INDEX %s", qualifiedName(c2.get("schema_name"), c2.get("table_name")), quoteIdent(c.get("index_name")), quoteIdent(c.get("index_name")))
 				} else {
 
 				}
 			} else {
 				// Drop the c2 index, create a copy of the c1 index
-				fmt.Printf("DROP INDEX %s; -- %s \n", c2.get("index_name"), c2.get("index_def"))
+				change.addWarning("Dropping %s", c2.get("index_def"))
+				change.addSql("DROP INDEX %s", qualifiedName(c2.get("schema_name"), c2.get("index_name")))
 			}
 			// WIP
 			//fmt.Printf("ALTER TABLE %s ADD CONSTRAINT %s %s;\n", c.get("table_name"), c.get("index_name"), c.get("constraint_def"))
@@ -227,7 +281,7 @@ func (c *IndexSchema) Change(obj interface{}) {
 			// The constraints match
 		}
 
-		return
+		return change
 	}
 
 	// At this point, we know that the constraint_def matches.  Compare the index_def
@@ -240,8 +294,8 @@ func (c *IndexSchema) Change(obj interface{}) {
 	if dbInfo1.DbSchema != dbInfo2.DbSchema {
 		indexDef1 = strings.Replace(
 			indexDef1,
-			fmt.Sprintf(" %s.%s ", c.get("schema_name"), c.get("table_name")),
-			fmt.Sprintf(" %s.%s ", c2.get("schema_name"), c2.get("table_name")),
+			" "+qualifiedName(c.get("schema_name"), c.get("table_name"))+" ",
+			" "+qualifiedName(c2.get("schema_name"), c2.get("table_name"))+" ",
 			-1,
 		)
 	}
@@ -251,47 +305,63 @@ func (c *IndexSchema) Change(obj interface{}) {
 		// The indexes do not match, but the constraints do
 		if !strings.HasPrefix(c.get("index_def"), c2.get("index_def")) &&
 			!strings.HasPrefix(c2.get("index_def"), c.get("index_def")) {
-			fmt.Println("--\n--CHANGE: index defs are different for identical constraint defs:")
-			fmt.Printf("--    %s\n--    %s\n", c.get("index_def"), c2.get("index_def"))
+			change.addWarning("CHANGE: index defs are different for identical constraint defs:")
+			change.addWarning("   %s", c.get("index_def"))
+			change.addWarning("   %s", c2.get("index_def"))
 
 			// Drop the index (and maybe the constraint) so we can recreate the index
-			c.Drop()
+			c.addDrop(change)
 
 			// Recreate the index (and a constraint if specified)
-			c.Add()
+			schema := dbInfo2.DbSchema
+			if schema == "*" {
+				schema = c.get("schema_name")
+			}
+			c.addCreate(change, schema)
 		}
 	}
 
+	return change
 }
 
-// compareIndexes outputs Sql to make the indexes match between to DBs or schemas
-func compareIndexes(conn1 *sql.DB, conn2 *sql.DB) {
-
-	buf1 := new(bytes.Buffer)
-	indexSqlTemplate.Execute(buf1, dbInfo1)
+// compareIndexes returns the changes needed to make the indexes match between to DBs or schemas
+func compareIndexes(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	buf2 := new(bytes.Buffer)
-	indexSqlTemplate.Execute(buf2, dbInfo2)
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	rows1 := make(IndexRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := IndexRows(cat1.Query("INDEX", indexSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(IndexRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := IndexRows(cat2.Query("INDEX", indexSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here for some unknown reason
 	var schema1 Schema = &IndexSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &IndexSchema{rows: rows2, rowNum: -1}
 
-	// Compare the indexes
-	doDiff(schema1, schema2)
+	// Compare the indexes.  The primary keys of new tables are part of CREATE TABLE
+	// (see addedTables).  The indexes of partitions that belong to an index of the
+	// partitioned table are created with the partition or that index, and are dropped
+	// with them.
+	added := addedTables(cat1, cat2)
+	diffs := doDiff(schema1, schema2)
+	addedIndexes := make(map[string]bool)
+	for _, change := range diffs {
+		if change.Action == ActionAdd {
+			addedIndexes[change.DB1["schema_name"]+"."+change.DB1["index_name"]] = true
+		}
+	}
+	var changes []*SchemaChange
+	for _, change := range diffs {
+		row1 := change.DB1
+		newTable := change.Action == ActionAdd && added[row1["schema_name"]+"."+row1["table_name"]]
+		switch {
+		case newTable && row1["pk"] == "true":
+			continue
+		case change.Action == ActionAdd && isChildIndex(row1) && (newTable || addedIndexes[row1["parent_index_schema"]+"."+row1["parent_index"]]):
+			continue
+		case change.Action == ActionDrop && isChildIndex(change.DB2):
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
 }
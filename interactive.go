@@ -0,0 +1,99 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// interactive.go walks through the schema types one at a time, letting you edit the
+// generated SQL and apply it to db2 before moving on.  It replaces pgdiff.sh.
+//
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// runInteractive generates the SQL for each schema type in turn and writes it to a
+// numbered file in the current directory (1-ROLE.sql, 2-SCHEMA.sql, etc).  The
+// file is opened in $EDITOR, and when you confirm, it is applied to db2 in a
+// transaction (see applyScript).  The diff for a type can be rerun (after fixing
+// the order of dependent views, for example) before continuing to the next type.
+func runInteractive(cat1 Catalog, cat2 *dbCatalog) {
+	in := bufio.NewReader(os.Stdin)
+
+	for i, schemaType := range allSchemaTypes {
+		sqlFile := fmt.Sprintf("%d-%s.sql", i+1, schemaType)
+		rerun := true
+		for rerun {
+			rerun = false
+			fmt.Printf("Generating diff for %s... \n", schemaType)
+			changes, _ := compareSchemaType(schemaType, cat1, cat2)
+			if len(changes) == 0 {
+				prompt(in, fmt.Sprintf("No changes found for %s (Press Enter) ", schemaType))
+				continue
+			}
+
+			file, err := os.Create(sqlFile)
+			check("creating "+sqlFile, err)
+			fmt.Fprintln(file, "-- schemaType:", schemaType)
+			fmt.Fprintln(file, "-- Run the following SQL against db2:")
+			renderSql(file, changes)
+			file.Close()
+
+			check("editing "+sqlFile, editFile(sqlFile))
+
+			if !confirm(in, fmt.Sprintf("Do you wish to run this against %s? [yN]: ", cat2.dbInfo.DbName)) {
+				continue
+			}
+			script, err := ioutil.ReadFile(sqlFile)
+			check("reading "+sqlFile, err)
+			applyScript(os.Stdout, cat2.conn, string(script), continueOnError)
+			rerun = confirm(in, fmt.Sprintf("Rerun diff for %s? [yN]: ", schemaType))
+		}
+		fmt.Println()
+	}
+
+	fmt.Println()
+	fmt.Println("Done!")
+}
+
+// editFile opens the file in the editor named by $EDITOR (which may include
+// arguments, like "code --wait").  vi is used when $EDITOR isn't set, or notepad
+// on Windows.
+func editFile(fileName string) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		} else {
+			editor = []string{"vi"}
+		}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], fileName)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// prompt prints the message and returns the line that is typed in response
+func prompt(in *bufio.Reader, message string) string {
+	fmt.Print(message)
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		check("reading input", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// confirm asks a yes/no question where no is the default
+func confirm(in *bufio.Reader, message string) bool {
+	return strings.HasPrefix(strings.ToLower(prompt(in, message)), "y")
+}
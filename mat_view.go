@@ -7,14 +7,47 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
 )
 
+var (
+	matViewSqlTemplate = initMatViewSqlTemplate()
+)
+
+// Initializes the Sql template
+func initMatViewSqlTemplate() *template.Template {
+	sql := `
+	WITH matviews as ( SELECT schemaname || '.' || matviewname AS matviewname,
+	schemaname AS schema_name,
+	matviewname AS matview_name,
+	definition
+	FROM pg_catalog.pg_matviews 
+	WHERE {{$.SchemaFilter "schemaname"}}
+	AND {{$.ExtensionFilter "pg_class" "format('%I.%I', schemaname, matviewname)::regclass::oid"}}
+	)
+	SELECT
+	matviewname,
+	schema_name,
+	matview_name,
+	definition,
+	COALESCE(string_agg(indexdef, ';' || E'\n\n') || ';', '')  as indexdef
+	FROM matviews
+	LEFT JOIN  pg_catalog.pg_indexes on matviewname = schemaname || '.' || tablename
+	group by matviewname, schema_name, matview_name, definition
+	ORDER BY
+	matviewname;
+	`
+	t := template.New("MatViewSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
 // ==================================
 // MatViewRows definition
 // ==================================
@@ -65,7 +98,7 @@ func (c *MatViewSchema) NextRow() bool {
 func (c *MatViewSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*MatViewSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a MatViewSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a MatViewSchema instance", c2)
 		return +999
 	}
 
@@ -74,60 +107,64 @@ func (c *MatViewSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the materialized view in the current row when ordering by dependency
+func (c *MatViewSchema) ObjectKey() string {
+	return "matview:" + c.get("matviewname")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *MatViewSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to create the matview
-func (c MatViewSchema) Add() {
-	fmt.Printf("CREATE MATERIALIZED VIEW %s AS %s \n\n%s \n\n", c.get("matviewname"), c.get("definition"), c.get("indexdef"))
+func (c MatViewSchema) Add() *SchemaChange {
+	change := newChange("MATVIEW", ActionAdd, "", c.get("matviewname"))
+	c.addCreate(change)
+	return change
 }
 
 // Drop returns SQL to drop the matview
-func (c MatViewSchema) Drop() {
-	fmt.Printf("DROP MATERIALIZED VIEW %s;\n\n", c.get("matviewname"))
+func (c MatViewSchema) Drop() *SchemaChange {
+	change := newChange("MATVIEW", ActionDrop, "", c.get("matviewname"))
+	change.Destructive = true
+	change.addSql("DROP MATERIALIZED VIEW %s", qualifiedName(c.get("schema_name"), c.get("matview_name")))
+	return change
 }
 
 // Change handles the case where the names match, but the definition does not
-func (c MatViewSchema) Change(obj interface{}) {
+func (c MatViewSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*MatViewSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a MatViewSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a MatViewSchema instance", c2)
 	}
+	change := newChange("MATVIEW", ActionChange, "", c.get("matviewname"))
 	if c.get("definition") != c2.get("definition") {
-		fmt.Printf("DROP MATERIALIZED VIEW %s;\n\n", c.get("matviewname"))
-		fmt.Printf("CREATE MATERIALIZED VIEW %s AS %s \n\n%s \n\n", c.get("matviewname"), c.get("definition"), c.get("indexdef"))
+		change.addSql("DROP MATERIALIZED VIEW %s", qualifiedName(c.get("schema_name"), c.get("matview_name")))
+		c.addCreate(change)
 	}
+	return change
 }
 
-// compareMatViews outputs SQL to make the matviews match between DBs
-func compareMatViews(conn1 *sql.DB, conn2 *sql.DB) {
-	sql := `
-	WITH matviews as ( SELECT schemaname || '.' || matviewname AS matviewname,
-	definition
-	FROM pg_catalog.pg_matviews 
-	WHERE schemaname NOT LIKE 'pg_%' 
-	)
-	SELECT
-	matviewname,
-	definition,
-	COALESCE(string_agg(indexdef, ';' || E'\n\n') || ';', '')  as indexdef
-	FROM matviews
-	LEFT JOIN  pg_catalog.pg_indexes on matviewname = schemaname || '.' || tablename
-	group by matviewname, definition
-	ORDER BY
-	matviewname;
-	`
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, sql)
-	rowChan2, _ := pgutil.QueryStrings(conn2, sql)
-
-	rows1 := make(MatViewRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
+// addCreate adds the SQL to create the matview and its indexes to the change
+func (c MatViewSchema) addCreate(change *SchemaChange) {
+	change.addSql("CREATE MATERIALIZED VIEW %s AS %s", qualifiedName(c.get("schema_name"), c.get("matview_name")), viewDefinition(c.get("definition")))
+	for _, indexDef := range strings.Split(c.get("indexdef"), ";") {
+		if indexDef = strings.TrimSpace(indexDef); len(indexDef) > 0 {
+			change.addSql("%s", indexDef)
+		}
 	}
+}
+
+// compareMatViews returns the changes needed to make the matviews match between DBs
+func compareMatViews(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	rows1 := MatViewRows(cat1.Query("MATVIEW", matViewSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(MatViewRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := MatViewRows(cat2.Query("MATVIEW", matViewSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here
@@ -135,5 +172,5 @@ func compareMatViews(conn1 *sql.DB, conn2 *sql.DB) {
 	var schema2 Schema = &MatViewSchema{rows: rows2, rowNum: -1}
 
 	// Compare the matviews
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"text/template"
 )
@@ -23,24 +21,57 @@ var (
 // Initializes the Sql template
 func initOwnerSqlTemplate() *template.Template {
 	sql := `
-SELECT n.nspname AS schema_name
-    , {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}c.relname || '.' || c.relname AS compare_name
-    , c.relname AS relationship_name
+WITH owned (type, schema_name, relationship_name, arguments, arg_types, language, owner) AS (
+    SELECT 'SCHEMA', n.nspname, NULL, NULL, NULL, NULL, n.nspowner
+    FROM pg_catalog.pg_namespace AS n
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    UNION ALL
+    SELECT CASE c.relkind WHEN 'S' THEN 'SEQUENCE' WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW'
+           WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END
+        , n.nspname, c.relname, NULL, NULL, NULL, c.relowner
+    FROM pg_catalog.pg_class AS c
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+    WHERE c.relkind IN ('r', 'p', 'S', 'v', 'm', 'f')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+    -- The sequence of an identity column always has the owner of its table
+    AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend AS i
+        WHERE i.classid = 'pg_class'::regclass AND i.objid = c.oid AND i.deptype = 'i')
+    UNION ALL
+    SELECT CASE p.prokind WHEN 'a' THEN 'AGGREGATE' WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END
+        , n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid)
+        , pg_catalog.oidvectortypes(p.proargtypes)
+        -- for --languages, which leaves out no aggregates
+        , CASE WHEN p.prokind <> 'a' THEN l.lanname END
+        , p.proowner
+    FROM pg_catalog.pg_proc AS p
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = p.pronamespace)
+    INNER JOIN pg_catalog.pg_language AS l ON (l.oid = p.prolang)
+    WHERE {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_proc" "p.oid"}}
+    UNION ALL
+    -- Enums, composite types, domains, and range types
+    SELECT CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END
+        , n.nspname, t.typname, NULL, NULL, NULL, t.typowner
+    FROM pg_catalog.pg_type AS t
+    INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+    LEFT OUTER JOIN pg_catalog.pg_class AS c ON (c.oid = t.typrelid)
+    WHERE t.typtype IN ('e', 'c', 'd', 'r')
+    AND (t.typtype <> 'c' OR c.relkind = 'c')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_type" "t.oid"}}
+)
+SELECT o.type || ':' || concat_ws('.', {{if eq $.DbSchema "*" }}o.schema_name, {{end}}o.relationship_name)
+        || COALESCE('(' || o.arg_types || ')', '') AS compare_name
+    , o.type
+    , o.schema_name
+    , o.relationship_name
+    , o.arguments
+    , o.arg_types
+    , o.language
     , a.rolname AS owner
-    , CASE WHEN c.relkind = 'r' THEN 'TABLE' 
-        WHEN c.relkind = 'S' THEN 'SEQUENCE' 
-        WHEN c.relkind = 'v' THEN 'VIEW' 
-        ELSE c.relkind::varchar END AS type
-FROM pg_class AS c
-INNER JOIN pg_roles AS a ON (a.oid = c.relowner)
-INNER JOIN pg_namespace AS n ON (n.oid = c.relnamespace)
-WHERE c.relkind IN ('r', 'S', 'v')
-{{if eq $.DbSchema "*" }}
-AND n.nspname NOT LIKE 'pg_%' 
-AND n.nspname <> 'information_schema'
-{{else}}
-AND n.nspname = '{{$.DbSchema}}'
-{{end}}
+FROM owned AS o
+INNER JOIN pg_catalog.pg_roles AS a ON (a.oid = o.owner)
 ;`
 
 	t := template.New("OwnerSqlTmpl")
@@ -109,7 +140,7 @@ func (c *OwnerSchema) NextRow() bool {
 func (c *OwnerSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*OwnerSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare needs a OwnerSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare needs a OwnerSchema instance", c2)
 		return +999
 	}
 
@@ -117,55 +148,123 @@ func (c *OwnerSchema) Compare(obj interface{}) int {
 	return val
 }
 
-// Add generates SQL to add the table/view owner
-func (c OwnerSchema) Add() {
-	fmt.Printf("-- Notice!, db2 has no %s named %s.  First, run pgdiff with the %s option.\n", c.get("type"), c.get("relationship_name"), c.get("type"))
+// ObjectKey identifies the owned object in the current row when ordering by dependency
+func (c *OwnerSchema) ObjectKey() string {
+	switch c.get("type") {
+	case "SCHEMA":
+		return "schema:" + c.get("schema_name")
+	case "FUNCTION", "PROCEDURE", "AGGREGATE":
+		return "function:" + c.get("schema_name") + "." + c.get("relationship_name") + "(" + c.get("arg_types") + ")"
+	case "TYPE", "DOMAIN":
+		return "type:" + c.get("schema_name") + "." + c.get("relationship_name")
+	}
+	return relationKey(c.get("type"), c.get("schema_name"), c.get("relationship_name"))
 }
 
-// Drop generates SQL to drop the owner
-func (c OwnerSchema) Drop() {
-	fmt.Printf("-- Notice!, db2 has a %s that db1 does not: %s.   First, run pgdiff with the %s option.\n", c.get("type"), c.get("relationship_name"), c.get("type"))
+// Row returns the current row, or nil when there isn't one
+func (c *OwnerSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
 }
 
-// Change handles the case where the relationship name matches, but the owner does not
-func (c OwnerSchema) Change(obj interface{}) {
-	c2, ok := obj.(*OwnerSchema)
-	if !ok {
-		fmt.Println("-- Error!!!, Change needs a OwnerSchema instance", c2)
+// schema returns the schema of the object in db2
+func (c *OwnerSchema) schema() string {
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		return dbInfo2.DbSchema
 	}
+	return c.get("schema_name")
+}
 
-	if c.get("owner") != c2.get("owner") {
-		fmt.Printf("ALTER %s %s.%s OWNER TO %s; \n", c.get("type"), c2.get("schema_name"), c.get("relationship_name"), c.get("owner"))
+// newChange returns an empty change named for the object in the given schema
+func (c *OwnerSchema) newChange(action string, schema string) *SchemaChange {
+	if c.get("type") == "SCHEMA" {
+		return newChange("OWNER", action, "", schema)
+	}
+	name := c.get("relationship_name")
+	if c.get("arg_types") != "null" && len(c.get("arg_types")) > 0 {
+		name += "(" + c.get("arg_types") + ")"
 	}
+	return newChange("OWNER", action, schema, name)
 }
 
-// compareOwners compares the ownership of tables, sequences, and views between two databases or schemas
-func compareOwners(conn1 *sql.DB, conn2 *sql.DB) {
+// addOwner adds the SQL to give the object in the given schema the db1 owner
+func (c *OwnerSchema) addOwner(change *SchemaChange, schema string) {
+	target := qualifiedName(schema, c.get("relationship_name"))
+	switch c.get("type") {
+	case "SCHEMA":
+		target = quoteIdent(schema)
+	case "FUNCTION", "PROCEDURE", "AGGREGATE":
+		target += "(" + c.get("arguments") + ")"
+	}
+	change.addSql("ALTER %s %s OWNER TO %s", c.get("type"), target, quoteIdent(c.get("owner")))
+}
 
-	buf1 := new(bytes.Buffer)
-	ownerSqlTemplate.Execute(buf1, dbInfo1)
+// Add generates SQL to give a new object its owner.  The other schema types create it
+// (so with ALL this comes after the object is created).
+func (c OwnerSchema) Add() *SchemaChange {
+	schema := c.schema()
+	change := c.newChange(ActionAdd, schema)
+	c.addOwner(change, schema)
+	return change
+}
 
-	buf2 := new(bytes.Buffer)
-	ownerSqlTemplate.Execute(buf2, dbInfo2)
+// Drop returns nothing because the owner goes with the dropped object
+func (c OwnerSchema) Drop() *SchemaChange {
+	return nil
+}
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
+// Change handles the case where the relationship name matches, but the owner does not
+func (c OwnerSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*OwnerSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a OwnerSchema instance", c2)
+	}
 
-	rows1 := make(OwnerRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
+	schema := c2.schema()
+	change := c.newChange(ActionChange, schema)
+	if c.get("owner") != c2.get("owner") {
+		c.addOwner(change, schema)
 	}
-	sort.Sort(rows1)
+	return change
+}
+
+// compareOwners compares the owners of schemas, tables, sequences, views, materialized
+// views, foreign tables, functions, and types between two databases or schemas
+func compareOwners(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	return diffOwners(ownerRows(cat1), ownerRows(cat2))
+}
 
-	rows2 := make(OwnerRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
+// compareFunctionOwners compares the owners of functions, procedures, and aggregates
+// only.  The FUNCTION schema type includes these changes.
+func compareFunctionOwners(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	functionRows := func(rows OwnerRows) OwnerRows {
+		var functions OwnerRows
+		for _, row := range rows {
+			switch row["type"] {
+			case "FUNCTION", "PROCEDURE", "AGGREGATE":
+				functions = append(functions, row)
+			}
+		}
+		return functions
 	}
-	sort.Sort(rows2)
+	return diffOwners(functionRows(ownerRows(cat1)), functionRows(ownerRows(cat2)))
+}
+
+// ownerRows returns the sorted OWNER rows, leaving out the functions in the languages
+// that --languages leaves out (just like FUNCTION does)
+func ownerRows(cat Catalog) OwnerRows {
+	rows := OwnerRows(filterLanguages(cat.Query("OWNER", ownerSqlTemplate)))
+	sort.Sort(rows)
+	return rows
+}
 
+// diffOwners returns the changes needed to make the owners in rows2 match rows1
+func diffOwners(rows1 OwnerRows, rows2 OwnerRows) []*SchemaChange {
 	// We have to explicitly type this as Schema here for some unknown reason
 	var schema1 Schema = &OwnerSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &OwnerSchema{rows: rows2, rowNum: -1}
 
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
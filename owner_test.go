@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_OwnerSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row := func(objectType, name, arguments, argTypes, owner string) map[string]string {
+		return map[string]string{"type": objectType, "schema_name": "s1", "relationship_name": name,
+			"arguments": arguments, "arg_types": argTypes, "owner": owner}
+	}
+	tests := []struct {
+		row      map[string]string
+		expected string
+	}{
+		{row("SCHEMA", "null", "null", "null", "app"), "ALTER SCHEMA s1 OWNER TO app"},
+		{row("TABLE", "events", "null", "null", "app"), "ALTER TABLE s1.events OWNER TO app"},
+		{row("MATERIALIZED VIEW", "totals", "null", "null", "Report Owner"), `ALTER MATERIALIZED VIEW s1.totals OWNER TO "Report Owner"`},
+		{row("FOREIGN TABLE", "remote_events", "null", "null", "app"), "ALTER FOREIGN TABLE s1.remote_events OWNER TO app"},
+		{row("FUNCTION", "total", "a integer, b integer", "integer, integer", "app"), "ALTER FUNCTION s1.total(a integer, b integer) OWNER TO app"},
+		{row("AGGREGATE", "product", "numeric", "numeric", "app"), "ALTER AGGREGATE s1.product(numeric) OWNER TO app"},
+		{row("DOMAIN", "email", "null", "null", "app"), "ALTER DOMAIN s1.email OWNER TO app"},
+	}
+	for _, test := range tests {
+		c := &OwnerSchema{rows: OwnerRows{test.row}, rowNum: 0}
+		expectStatements(t, c.Add(), test.expected)
+	}
+
+	c1 := &OwnerSchema{rows: OwnerRows{row("FUNCTION", "total", "a integer", "integer", "app")}, rowNum: 0}
+	c2 := &OwnerSchema{rows: OwnerRows{row("FUNCTION", "total", "a integer", "integer", "postgres")}, rowNum: 0}
+	change := c1.Change(c2)
+	expectStatements(t, change, "ALTER FUNCTION s1.total(a integer) OWNER TO app")
+	if change.Name != "s1.total(integer)" || c1.ObjectKey() != "function:s1.total(integer)" {
+		t.Errorf("Wrong name or key: %s %s", change.Name, c1.ObjectKey())
+	}
+	if c1.Drop() != nil {
+		t.Errorf("The owner of a dropped object should have no change")
+	}
+}
+
+func Test_compareFunctionOwners(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	functionLanguages = "plpgsql"
+	defer func() { functionLanguages = "*" }()
+	cat1 := testCatalog{"OWNER": {
+		{"compare_name": "FUNCTION:s1.f(integer)", "type": "FUNCTION", "schema_name": "s1", "relationship_name": "f",
+			"arguments": "a integer", "arg_types": "integer", "language": "plpgsql", "owner": "app"},
+		{"compare_name": "FUNCTION:s1.g()", "type": "FUNCTION", "schema_name": "s1", "relationship_name": "g",
+			"arguments": "", "arg_types": "", "language": "sql", "owner": "app"},
+		{"compare_name": "TABLE:s1.t", "type": "TABLE", "schema_name": "s1", "relationship_name": "t",
+			"arguments": "null", "arg_types": "null", "language": "null", "owner": "app"},
+	}}
+
+	// The function in another language is not created by FUNCTION, so it gets no owner
+	changes := compareOwners(cat1, testCatalog{})
+	if len(changes) != 2 || changes[0].Name != "s1.f(integer)" || changes[1].Name != "s1.t" {
+		t.Errorf("Wrong owner changes: %v", changes)
+	}
+
+	changes, _ = compareSchemaType("FUNCTION", testCatalog{"OWNER": cat1["OWNER"][:1]}, testCatalog{})
+	if len(changes) != 1 || changes[0].Kind != "OWNER" {
+		t.Fatalf("FUNCTION should include the owners of functions: %v", changes)
+	}
+	expectStatements(t, changes[0], "ALTER FUNCTION s1.f(a integer) OWNER TO app")
+}
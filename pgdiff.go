@@ -7,6 +7,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 
@@ -20,13 +21,16 @@ import (
 )
 
 // Schema is a database definition (table, column, constraint, indes, role, etc) that can be
-// added, dropped, or changed to match another database.
+// added, dropped, or changed to match another database.  Add, Drop, and Change return a
+// SchemaChange (defined in change.go) describing the SQL needed for the current row.
 type Schema interface {
 	Compare(schema interface{}) int
-	Add()
-	Drop()
-	Change(schema interface{})
+	Add() *SchemaChange
+	Drop() *SchemaChange
+	Change(schema interface{}) *SchemaChange
 	NextRow() bool
+	ObjectKey() string
+	Row() map[string]string
 }
 
 const (
@@ -34,10 +38,18 @@ const (
 )
 
 var (
-	args       []string
-	dbInfo1    pgutil.DbInfo
-	dbInfo2    pgutil.DbInfo
-	schemaType string
+	args               []string
+	dbInfo1            pgutil.DbInfo
+	dbInfo2            pgutil.DbInfo
+	snapshotFile1      string
+	snapshotFile2      string
+	outputFormat       string
+	checkOnly          bool
+	applyChanges       bool
+	continueOnError    bool
+	functionLanguages  string
+	syncSequenceValues bool
+	schemaType         string
 )
 
 /*
@@ -72,10 +84,37 @@ func main() {
 	}
 
 	if len(args) == 0 {
-		fmt.Println("The required first argument is SchemaType: SCHEMA, ROLE, SEQUENCE, TABLE, VIEW, MATVIEW, COLUMN, INDEX, FOREIGN_KEY, OWNER, GRANT_RELATIONSHIP, GRANT_ATTRIBUTE")
+		fmt.Println("The required first argument is SchemaType: SCHEMA, ROLE, EXTENSION, SEQUENCE, TYPE, TABLE, VIEW, MATVIEW, COLUMN, INDEX, FOREIGN_KEY, CHECK_CONSTRAINT, POLICY, OWNER, GRANT_RELATIONSHIP, GRANT_ATTRIBUTE, GRANT_SCHEMA, GRANT_FUNCTION, GRANT_TYPE, GRANT_LANGUAGE, GRANT_FOREIGN_SERVER, GRANT_DATABASE, DEFAULT_PRIVILEGE, COMMENT")
 		os.Exit(1)
 	}
 
+	schemaType = strings.ToUpper(args[0])
+
+	if schemaType == "SNAPSHOT" {
+		if len(args) > 2 {
+			fmt.Println("SNAPSHOT takes at most one argument: the file to write")
+			os.Exit(1)
+		}
+		fileName := ""
+		if len(args) == 2 {
+			fileName = args[1]
+		}
+		saveSnapshot(newDbCatalog(&dbInfo1), fileName)
+		return
+	}
+
+	if outputFormat != FormatSql && outputFormat != FormatJson && outputFormat != FormatYaml {
+		fmt.Println("--format must be sql, json, or yaml")
+		os.Exit(1)
+	}
+
+	// A snapshot replaces the database info for its side, so open them before the
+	// schemas are verified.  A schema given for that side must be the snapshot's.
+	cat1 := openCatalog(&dbInfo1, snapshotFile1)
+	checkSnapshotSchema("schema1", snapshotFile1, dbInfo1.DbSchema)
+	cat2 := openCatalog(&dbInfo2, snapshotFile2)
+	checkSnapshotSchema("schema2", snapshotFile2, dbInfo2.DbSchema)
+
 	// Verify schemas
 	schemas := dbInfo1.DbSchema + dbInfo2.DbSchema
 	if schemas != "**" && strings.Contains(schemas, "*") {
@@ -83,80 +122,227 @@ func main() {
 		os.Exit(1)
 	}
 
-	schemaType = strings.ToUpper(args[0])
-	fmt.Println("-- schemaType:", schemaType)
+	if applyChanges && checkOnly {
+		fmt.Println("--apply and --check can't be used together")
+		os.Exit(1)
+	}
+	_, db2IsDatabase := cat2.(*dbCatalog)
+	if applyChanges && !db2IsDatabase {
+		fmt.Println("--apply changes db2, so it can't be a snapshot")
+		os.Exit(1)
+	}
 
-	fmt.Println("-- db1:", dbInfo1)
-	fmt.Println("-- db2:", dbInfo2)
-	fmt.Println("-- Run the following SQL against db2:")
+	// Snapshots leave out the current values of sequences
+	_, db1IsDatabase := cat1.(*dbCatalog)
+	if syncSequenceValues && !(db1IsDatabase && db2IsDatabase) {
+		fmt.Println("--sync-sequence-values reads the sequences of both databases, so neither can be a snapshot")
+		os.Exit(1)
+	}
 
-	conn1, err := dbInfo1.Open()
-	check("opening database 1", err)
+	if schemaType == "INTERACTIVE" {
+		db2, ok := cat2.(*dbCatalog)
+		if !ok {
+			fmt.Println("INTERACTIVE changes db2, so it can't be a snapshot")
+			os.Exit(1)
+		}
+		runInteractive(cat1, db2)
+		return
+	}
+
+	// The JSON and YAML formats must contain nothing but the records, and --check
+	// prints nothing but its summary
+	if outputFormat == FormatSql && !checkOnly {
+		fmt.Println("-- schemaType:", schemaType)
 
-	conn2, err := dbInfo2.Open()
-	check("opening database 2", err)
+		if len(snapshotFile1) > 0 {
+			fmt.Println("-- db1: snapshot", snapshotFile1)
+		} else {
+			fmt.Println("-- db1:", dbInfo1)
+		}
+		if len(snapshotFile2) > 0 {
+			fmt.Println("-- db2: snapshot", snapshotFile2)
+		} else {
+			fmt.Println("-- db2:", dbInfo2)
+		}
+		fmt.Println("-- Run the following SQL against db2:")
+	}
+
+	changes, ok := compareSchemaType(schemaType, cat1, cat2)
+	if !ok {
+		fmt.Println("Not yet handled:", schemaType)
+		os.Exit(1)
+	}
 
-	// This section needs to be improved so that you do not need to choose the type
-	// of alter statements to generate.  Rather, all should be generated in the
-	// proper order.
+	if checkOnly {
+		// A drift check prints a summary instead of SQL unless another format was
+		// asked for, and fails when anything differs
+		if outputFormat == FormatSql {
+			renderSummary(os.Stdout, changes)
+		} else {
+			render(os.Stdout, outputFormat, changes)
+		}
+		if len(changes) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	render(os.Stdout, outputFormat, changes)
+
+	// --apply runs the same script that is printed for the sql format
+	if applyChanges && len(changes) > 0 {
+		script := new(bytes.Buffer)
+		renderSql(script, changes)
+		if !applyScript(os.Stderr, cat2.(*dbCatalog).conn, script.String(), continueOnError) {
+			os.Exit(1)
+		}
+	}
+}
+
+// allSchemaTypes are the schema types that ALL compares, in order.  INTERACTIVE runs
+// them one at a time in the same order.
+var allSchemaTypes = []string{
+	"ROLE",
+	"SCHEMA",
+	"EXTENSION",
+	"SEQUENCE",
+	"TYPE",
+	"TABLE",
+	"COLUMN",
+	"INDEX", // includes PK and Unique constraints
+	"VIEW",
+	"MATVIEW",
+	"FOREIGN_KEY",
+	"CHECK_CONSTRAINT",
+	"FUNCTION",
+	"TRIGGER",
+	"POLICY",
+	"OWNER",
+	"GRANT_RELATIONSHIP",
+	"GRANT_ATTRIBUTE",
+	"GRANT_SCHEMA",
+	"GRANT_FUNCTION",
+	"GRANT_TYPE",
+	"GRANT_LANGUAGE",
+	"GRANT_FOREIGN_SERVER",
+	"GRANT_DATABASE",
+	"DEFAULT_PRIVILEGE",
+	"COMMENT",
+}
+
+// compareSchemaType returns the changes needed to make db2 match db1 for one schema
+// type.  It returns false if the schema type is not one pgdiff knows.
+func compareSchemaType(schemaType string, cat1 Catalog, cat2 Catalog) (changes []*SchemaChange, ok bool) {
+	loadKeywords(cat1, cat2)
+
+	// For ALL, the changes for every schema type are collected first and then printed in
+	// an order that respects the dependencies between objects in each database.
 	if schemaType == "ALL" {
-		if dbInfo1.DbSchema == "*" {
-			compareSchematas(conn1, conn2)
+		for _, t := range allSchemaTypes {
+			switch t {
+			case "SEQUENCE":
+				// The columns that own sequences don't exist yet
+				changes = append(changes, compareSequences(cat1, cat2)...)
+			case "COLUMN":
+				changes = append(changes, compareColumns(cat1, cat2)...)
+				changes = append(changes, compareSequenceOwners(cat1, cat2)...)
+			case "FUNCTION":
+				// OWNER sets the owners of functions
+				changes = append(changes, compareFunctions(cat1, cat2)...)
+			case "COMMENT":
+				// Nothing depends on a comment, so comments are set once everything
+				// else is done
+				changes = sortChanges(changes, loadDependencies(cat1), loadDependencies(cat2))
+				changes = append(changes, compareComments(cat1, cat2)...)
+			default:
+				more, _ := compareOneSchemaType(t, cat1, cat2)
+				changes = append(changes, more...)
+			}
 		}
-		compareSchematas(conn1, conn2)
-		compareRoles(conn1, conn2)
-		compareSequences(conn1, conn2)
-		compareTables(conn1, conn2)
-		compareColumns(conn1, conn2)
-		compareIndexes(conn1, conn2) // includes PK and Unique constraints
-		compareViews(conn1, conn2)
-		compareMatViews(conn1, conn2)
-		compareForeignKeys(conn1, conn2)
-		compareFunctions(conn1, conn2)
-		compareTriggers(conn1, conn2)
-		compareOwners(conn1, conn2)
-		compareGrantRelationships(conn1, conn2)
-		compareGrantAttributes(conn1, conn2)
-	} else if schemaType == "SCHEMA" {
-		compareSchematas(conn1, conn2)
+		return changes, true
+	}
+	return compareOneSchemaType(schemaType, cat1, cat2)
+}
+
+// compareOneSchemaType returns the changes for a schema type other than ALL
+func compareOneSchemaType(schemaType string, cat1 Catalog, cat2 Catalog) (changes []*SchemaChange, ok bool) {
+	if schemaType == "SCHEMA" {
+		changes = compareSchematas(cat1, cat2)
 	} else if schemaType == "ROLE" {
-		compareRoles(conn1, conn2)
+		changes = compareRoles(cat1, cat2)
+	} else if schemaType == "EXTENSION" {
+		changes = compareExtensions(cat1, cat2)
 	} else if schemaType == "SEQUENCE" {
-		compareSequences(conn1, conn2)
+		changes = append(compareSequences(cat1, cat2), compareSequenceOwners(cat1, cat2)...)
+	} else if schemaType == "TYPE" {
+		changes = compareTypes(cat1, cat2)
 	} else if schemaType == "TABLE" {
-		compareTables(conn1, conn2)
+		changes = compareTables(cat1, cat2)
 	} else if schemaType == "COLUMN" {
-		compareColumns(conn1, conn2)
+		changes = compareColumns(cat1, cat2)
 	} else if schemaType == "TABLE_COLUMN" {
-		compareTableColumns(conn1, conn2)
+		changes = compareTableColumns(cat1, cat2)
 	} else if schemaType == "INDEX" {
-		compareIndexes(conn1, conn2)
+		changes = compareIndexes(cat1, cat2)
 	} else if schemaType == "VIEW" {
-		compareViews(conn1, conn2)
+		changes = compareViews(cat1, cat2)
 	} else if schemaType == "MATVIEW" {
-		compareMatViews(conn1, conn2)
+		changes = compareMatViews(cat1, cat2)
 	} else if schemaType == "FOREIGN_KEY" {
-		compareForeignKeys(conn1, conn2)
+		changes = compareForeignKeys(cat1, cat2)
+	} else if schemaType == "CHECK_CONSTRAINT" {
+		changes = compareCheckConstraints(cat1, cat2)
 	} else if schemaType == "FUNCTION" {
-		compareFunctions(conn1, conn2)
+		// OWNER compares the owners of functions with everything else, but FUNCTION on
+		// its own still sets them
+		changes = append(compareFunctions(cat1, cat2), compareFunctionOwners(cat1, cat2)...)
 	} else if schemaType == "TRIGGER" {
-		compareTriggers(conn1, conn2)
+		changes = compareTriggers(cat1, cat2)
+	} else if schemaType == "POLICY" {
+		changes = comparePolicies(cat1, cat2)
 	} else if schemaType == "OWNER" {
-		compareOwners(conn1, conn2)
+		changes = compareOwners(cat1, cat2)
 	} else if schemaType == "GRANT_RELATIONSHIP" {
-		compareGrantRelationships(conn1, conn2)
+		changes = compareGrantRelationships(cat1, cat2)
 	} else if schemaType == "GRANT_ATTRIBUTE" {
-		compareGrantAttributes(conn1, conn2)
+		changes = compareGrantAttributes(cat1, cat2)
+	} else if schemaType == "GRANT_SCHEMA" {
+		changes = compareGrantSchemas(cat1, cat2)
+	} else if schemaType == "GRANT_FUNCTION" {
+		changes = compareGrantFunctions(cat1, cat2)
+	} else if schemaType == "GRANT_TYPE" {
+		changes = compareGrantTypes(cat1, cat2)
+	} else if schemaType == "GRANT_LANGUAGE" {
+		changes = compareGrantLanguages(cat1, cat2)
+	} else if schemaType == "GRANT_FOREIGN_SERVER" {
+		changes = compareGrantForeignServers(cat1, cat2)
+	} else if schemaType == "GRANT_DATABASE" {
+		changes = compareGrantDatabases(cat1, cat2)
+	} else if schemaType == "DEFAULT_PRIVILEGE" {
+		changes = compareDefaultPrivileges(cat1, cat2)
+	} else if schemaType == "COMMENT" {
+		changes = compareComments(cat1, cat2)
 	} else {
-		fmt.Println("Not yet handled:", schemaType)
+		return nil, false
 	}
+	return changes, true
+
 }
 
 /*
  * This is a generic diff function that compares tables, columns, indexes, roles, grants, etc.
  * Different behaviors are specified the Schema implementations
  */
-func doDiff(db1 Schema, db2 Schema) {
+func doDiff(db1 Schema, db2 Schema) []*SchemaChange {
+	var changes []*SchemaChange
+	keep := func(change *SchemaChange, key string, row1 map[string]string, row2 map[string]string) {
+		if change != nil && !change.isEmpty() {
+			change.key = key
+			change.DB1 = row1
+			change.DB2 = row2
+			changes = append(changes, change)
+		}
+	}
 
 	more1 := db1.NextRow()
 	more2 := db2.NextRow()
@@ -164,36 +350,39 @@ func doDiff(db1 Schema, db2 Schema) {
 		compareVal := db1.Compare(db2)
 		if compareVal == 0 {
 			// table and column match, look for non-identifying changes
-			db1.Change(db2)
+			keep(db1.Change(db2), db1.ObjectKey(), db1.Row(), db2.Row())
 			more1 = db1.NextRow()
 			more2 = db2.NextRow()
 		} else if compareVal < 0 {
 			// db2 is missing a value that db1 has
 			if more1 {
-				db1.Add()
+				keep(db1.Add(), db1.ObjectKey(), db1.Row(), nil)
 				more1 = db1.NextRow()
 			} else {
 				// db1 is at the end
-				db2.Drop()
+				keep(db2.Drop(), db2.ObjectKey(), nil, db2.Row())
 				more2 = db2.NextRow()
 			}
 		} else if compareVal > 0 {
 			// db2 has an extra column that we don't want
 			if more2 {
-				db2.Drop()
+				keep(db2.Drop(), db2.ObjectKey(), nil, db2.Row())
 				more2 = db2.NextRow()
 			} else {
 				// db2 is at the end
-				db1.Add()
+				keep(db1.Add(), db1.ObjectKey(), db1.Row(), nil)
 				more1 = db1.NextRow()
 			}
 		}
 	}
+	return changes
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "%s - version %s\n", os.Args[0], version)
 	fmt.Fprintf(os.Stderr, "usage: %s [<options>] <schemaType> \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [<db1 options>] SNAPSHOT [<file>]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [<options>] INTERACTIVE\n", os.Args[0])
 	fmt.Fprintln(os.Stderr, `
 Compares the schema between two PostgreSQL databases and generates alter statements 
 that can be *manually* run against the second database.
@@ -210,16 +399,52 @@ Options:
   -p, --port2   : second port. default is 5432 
   -D, --dbname1 : first database name 
   -d, --dbname2 : second database name 
-  -S, --schema1 : first schema.  default is all schemas
-  -s, --schema2 : second schema. default is all schemas
+  -S, --schema1 : first schema, or a comma separated list of schemas.  default is all schemas
+  -s, --schema2 : second schema, or a comma separated list of schemas. default is all schemas
+  --snapshot1   : snapshot file to read instead of connecting to the first database
+  --snapshot2   : snapshot file to read instead of connecting to the second database
+  --format      : output format: sql (default), json, or yaml
+  --apply       : run the generated SQL against the second database in a transaction
+                  that is rolled back if any statement fails
+  --continue-on-error : with --apply (or INTERACTIVE), skip failed statements using
+                  savepoints and commit the rest
+  --languages   : comma separated languages of the functions and procedures to
+                  compare (eg. plpgsql,plv8), or * for all.  default is c,plpgsql,sql
+  --sync-sequence-values : with SEQUENCE or ALL, move each db2 sequence forward to
+                  its current value in db1 with setval (never backwards)
+  --check       : print a summary of the differences instead of SQL and exit 1 if
+                  there are any (exit 0 if the databases match)
 
-<schemaTpe> can be: ALL, SCHEMA, ROLE, SEQUENCE, TABLE, TABLE_COLUMN, VIEW, MATVIEW, COLUMN, INDEX, FOREIGN_KEY, OWNER, GRANT_RELATIONSHIP, GRANT_ATTRIBUTE, TRIGGER, FUNCTION`)
+<schemaTpe> can be: ALL, SCHEMA, ROLE, EXTENSION, SEQUENCE, TYPE, TABLE, TABLE_COLUMN, VIEW, MATVIEW, COLUMN, INDEX, FOREIGN_KEY, CHECK_CONSTRAINT, OWNER, GRANT_RELATIONSHIP, GRANT_ATTRIBUTE, GRANT_SCHEMA, GRANT_FUNCTION, GRANT_TYPE, GRANT_LANGUAGE, GRANT_FOREIGN_SERVER, GRANT_DATABASE, DEFAULT_PRIVILEGE, TRIGGER, POLICY, FUNCTION, COMMENT
+
+SNAPSHOT saves the catalog of the first database to a JSON file (or stdout) that
+can be given to --snapshot1 or --snapshot2 later.
+
+INTERACTIVE runs each schema type in the recommended order, opens the SQL in $EDITOR,
+and (if you confirm) applies it to the second database in a transaction.`)
 
 	os.Exit(2)
 }
 
+// check exits with status 2 on an error, so that --check can use status 1 for drift
+// checkSnapshotSchema exits when the schema flag was given for a side that is read
+// from a snapshot and names a different schema than the snapshot was taken with
+func checkSnapshotSchema(flagName string, snapshotFile string, snapshotSchema string) {
+	if len(snapshotFile) == 0 {
+		return
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == flagName && f.Value.String() != snapshotSchema {
+			fmt.Fprintf(os.Stderr, "--%s is %s but snapshot %s was taken with schema %s.  Leave out --%s or take a new snapshot.\n",
+				flagName, f.Value.String(), snapshotFile, snapshotSchema, flagName)
+			os.Exit(1)
+		}
+	})
+}
+
 func check(msg string, err error) {
 	if err != nil {
-		log.Fatal("Error "+msg, err)
+		log.Print("Error "+msg, err)
+		os.Exit(2)
 	}
 }
@@ -5,7 +5,7 @@
 # You are also allowed to rerun the diff on a type before continuing to the next type.  This is
 # helpful when, for example dependent views are defined in the file before the view it depends on.
 #
-# If you convert this to a windows batch file (or, even better, a Go program), please share it.
+# pgdiff INTERACTIVE does the same thing without bash or pgrun, and also works on Windows.
 #
 # Example script usage:
 # USER1=db-user HOST1=db-server NAME1=db1 USER2=db-user HOST2=db-server NAME2=db2 pgdiff.sh
@@ -70,19 +70,31 @@ function rundiff() {
 }
 
 rundiff ROLE
-rundiff FUNCTION
 rundiff SCHEMA
+rundiff EXTENSION
 rundiff SEQUENCE
+rundiff TYPE
 rundiff TABLE
 rundiff COLUMN
-rundiff MATVIEW
 rundiff INDEX
 rundiff VIEW
+rundiff MATVIEW
+rundiff FOREIGN_KEY
+rundiff CHECK_CONSTRAINT
+rundiff FUNCTION
 rundiff TRIGGER
+rundiff POLICY
 rundiff OWNER
-rundiff FOREIGN_KEY
 rundiff GRANT_RELATIONSHIP
 rundiff GRANT_ATTRIBUTE
+rundiff GRANT_SCHEMA
+rundiff GRANT_FUNCTION
+rundiff GRANT_TYPE
+rundiff GRANT_LANGUAGE
+rundiff GRANT_FOREIGN_SERVER
+rundiff GRANT_DATABASE
+rundiff DEFAULT_PRIVILEGE
+rundiff COMMENT
 
 echo
 echo "Done!"
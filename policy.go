@@ -0,0 +1,302 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/template"
+
+	"github.com/joncrlsn/misc"
+)
+
+var (
+	policySqlTemplate = initPolicySqlTemplate()
+)
+
+// Initializes the Sql template
+func initPolicySqlTemplate() *template.Template {
+	sql := `
+SELECT {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}c.relname || '.' || p.polname AS compare_name
+    , n.nspname AS schema_name
+    , c.relname AS table_name
+    , p.polname AS policy_name
+    , CASE p.polcmd WHEN 'r' THEN 'SELECT' WHEN 'a' THEN 'INSERT' WHEN 'w' THEN 'UPDATE'
+      WHEN 'd' THEN 'DELETE' ELSE 'ALL' END AS command
+    , p.polpermissive AS permissive
+    -- The oid 0 is PUBLIC
+    , ARRAY(SELECT CASE WHEN pr.oid = 0 THEN 'public' ELSE r.rolname END
+        FROM unnest(p.polroles) AS pr(oid)
+        LEFT OUTER JOIN pg_catalog.pg_roles AS r ON (r.oid = pr.oid)
+        ORDER BY 1) AS roles
+    , pg_catalog.pg_get_expr(p.polqual, p.polrelid) AS using_expr
+    , pg_catalog.pg_get_expr(p.polwithcheck, p.polrelid) AS check_expr
+FROM pg_catalog.pg_policy AS p
+INNER JOIN pg_catalog.pg_class AS c ON (c.oid = p.polrelid)
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+WHERE {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+`
+	t := template.New("PolicySqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// PolicyRows definition
+// ==================================
+
+// PolicyRows is a sortable slice of string maps
+type PolicyRows []map[string]string
+
+func (slice PolicyRows) Len() int {
+	return len(slice)
+}
+
+func (slice PolicyRows) Less(i, j int) bool {
+	return slice[i]["compare_name"] < slice[j]["compare_name"]
+}
+
+func (slice PolicyRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// PolicySchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// PolicySchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type PolicySchema struct {
+	rows   PolicyRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *PolicySchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *PolicySchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *PolicySchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*PolicySchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a PolicySchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	return val
+}
+
+// ObjectKey identifies the policy in the current row when ordering by dependency
+func (c *PolicySchema) ObjectKey() string {
+	return "policy:" + c.get("schema_name") + "." + c.get("table_name") + "." + c.get("policy_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *PolicySchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to create the policy
+func (c *PolicySchema) Add() *SchemaChange {
+	// If we are comparing two different schemas against each other, the policy is
+	// created on the table in the db2 schema
+	schema := c.get("schema_name")
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		schema = dbInfo2.DbSchema
+	}
+	change := newChange("POLICY", ActionAdd, schema, c.get("table_name")+"."+c.get("policy_name"))
+	c.addCreate(change, schema)
+	return change
+}
+
+// addCreate adds the SQL to create the policy on the table in the given schema
+func (c *PolicySchema) addCreate(change *SchemaChange, schema string) {
+	stmt := fmt.Sprintf("CREATE POLICY %s ON %s", quoteIdent(c.get("policy_name")), qualifiedName(schema, c.get("table_name")))
+	if c.get("permissive") != "true" {
+		stmt += " AS RESTRICTIVE"
+	}
+	stmt += " FOR " + c.get("command") + " TO " + quoteIdents(parseArray(c.get("roles")))
+	if expr := c.expr("using_expr", schema); expr != "null" {
+		stmt += " USING (" + expr + ")"
+	}
+	if expr := c.expr("check_expr", schema); expr != "null" {
+		stmt += " WITH CHECK (" + expr + ")"
+	}
+	change.addSql("%s", stmt)
+}
+
+// expr returns the USING or WITH CHECK expression of the policy for a table in the
+// given schema
+func (c *PolicySchema) expr(key string, schema string) string {
+	return remapSchema(c.get(key), c.get("schema_name"), schema)
+}
+
+// Drop returns SQL to drop the policy
+func (c *PolicySchema) Drop() *SchemaChange {
+	change := newChange("POLICY", ActionDrop, c.get("schema_name"), c.get("table_name")+"."+c.get("policy_name"))
+	change.Destructive = true
+	c.addDrop(change, c.get("schema_name"))
+	return change
+}
+
+// addDrop adds the SQL to drop the policy from the table in the given schema
+func (c *PolicySchema) addDrop(change *SchemaChange, schema string) {
+	change.addSql("DROP POLICY %s ON %s", quoteIdent(c.get("policy_name")), qualifiedName(schema, c.get("table_name")))
+}
+
+// Change alters the roles and expressions of the policy.  A policy for a different
+// command, one that changes between permissive and restrictive, or one that loses an
+// expression can't be altered, so it is dropped and created again.
+func (c *PolicySchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*PolicySchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, PolicySchema.Change(obj) needs a PolicySchema instance", c2)
+	}
+	schema := c.get("schema_name")
+	if dbInfo1.DbSchema != dbInfo2.DbSchema {
+		schema = dbInfo2.DbSchema
+	}
+	change := newChange("POLICY", ActionChange, schema, c.get("table_name")+"."+c.get("policy_name"))
+
+	if c.get("command") != c2.get("command") || c.get("permissive") != c2.get("permissive") ||
+		(c.get("using_expr") == "null" && c2.get("using_expr") != "null") ||
+		(c.get("check_expr") == "null" && c2.get("check_expr") != "null") {
+		change.addWarning("This policy looks different so we'll drop and recreate it:")
+		c2.addDrop(change, schema)
+		c.addCreate(change, schema)
+		return change
+	}
+
+	stmt := ""
+	if c.get("roles") != c2.get("roles") {
+		stmt += " TO " + quoteIdents(parseArray(c.get("roles")))
+	}
+	if expr := c.expr("using_expr", schema); expr != c2.get("using_expr") {
+		stmt += " USING (" + expr + ")"
+	}
+	if expr := c.expr("check_expr", schema); expr != c2.get("check_expr") {
+		stmt += " WITH CHECK (" + expr + ")"
+	}
+	if len(stmt) > 0 {
+		change.addSql("ALTER POLICY %s ON %s%s", quoteIdent(c.get("policy_name")), qualifiedName(schema, c.get("table_name")), stmt)
+	}
+	return change
+}
+
+// ==================================
+// RowSecuritySchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// RowSecuritySchema compares the row level security flags of the tables.  It reads
+// the rows of the TABLE query, so it only adds its own Compare, Add, Drop, and Change.
+type RowSecuritySchema struct {
+	TableSchema
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *RowSecuritySchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*RowSecuritySchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a RowSecuritySchema instance", c2)
+		return +999
+	}
+	return c.TableSchema.Compare(&c2.TableSchema)
+}
+
+// Add returns SQL to turn on row level security for a new table
+func (c *RowSecuritySchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("table_schema")
+	}
+	change := newChange("POLICY", ActionAdd, schema, c.get("table_name"))
+	c.addFlags(change, qualifiedName(schema, c.get("table_name")), "false", "false")
+	return change
+}
+
+// Drop returns nothing because the row level security of a table goes with the table
+func (c *RowSecuritySchema) Drop() *SchemaChange {
+	return nil
+}
+
+// Change turns row level security on or off, and forces it on the table owner or not
+func (c *RowSecuritySchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*RowSecuritySchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, RowSecuritySchema.Change(obj) needs a RowSecuritySchema instance", c2)
+	}
+	change := newChange("POLICY", ActionChange, c2.get("table_schema"), c.get("table_name"))
+	c.addFlags(change, qualifiedName(c2.get("table_schema"), c2.get("table_name")), c2.get("row_security"), c2.get("force_row_security"))
+	return change
+}
+
+// addFlags adds the SQL to change the row level security flags of the table from
+// the given db2 values to the db1 values
+func (c *RowSecuritySchema) addFlags(change *SchemaChange, table string, rowSecurity2 string, force2 string) {
+	if rowSecurity := c.get("row_security") == "true"; rowSecurity != (rowSecurity2 == "true") {
+		if rowSecurity {
+			change.addSql("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table)
+		} else {
+			change.addSql("ALTER TABLE %s DISABLE ROW LEVEL SECURITY", table)
+		}
+	}
+	if force := c.get("force_row_security") == "true"; force != (force2 == "true") {
+		if force {
+			change.addSql("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table)
+		} else {
+			change.addSql("ALTER TABLE %s NO FORCE ROW LEVEL SECURITY", table)
+		}
+	}
+}
+
+// comparePolicies returns the changes needed to make the row level security flags
+// of the tables and their policies match between two databases or schemas
+func comparePolicies(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	tables1 := TableRows(cat1.Query("TABLE", tableSqlTemplate))
+	sort.Sort(tables1)
+
+	tables2 := TableRows(cat2.Query("TABLE", tableSqlTemplate))
+	sort.Sort(tables2)
+
+	rows1 := PolicyRows(cat1.Query("POLICY", policySqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := PolicyRows(cat2.Query("POLICY", policySqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here
+	var tableSchema1 Schema = &RowSecuritySchema{TableSchema{rows: tables1, rowNum: -1}}
+	var tableSchema2 Schema = &RowSecuritySchema{TableSchema{rows: tables2, rowNum: -1}}
+	var schema1 Schema = &PolicySchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &PolicySchema{rows: rows2, rowNum: -1}
+
+	// Compare the row level security flags, then the policies
+	return append(doDiff(tableSchema1, tableSchema2), doDiff(schema1, schema2)...)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_PolicySchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row1 := map[string]string{"schema_name": "app", "table_name": "account", "policy_name": "tenant_isolation",
+		"command": "ALL", "permissive": "true", "roles": "{app_user,\"Report Reader\"}",
+		"using_expr": "(tenant_id = current_setting('app.tenant_id'::text)::integer)", "check_expr": "null"}
+	row2 := map[string]string{"schema_name": "app", "table_name": "account", "policy_name": "tenant_isolation",
+		"command": "ALL", "permissive": "true", "roles": "{public}", "using_expr": "true", "check_expr": "null"}
+
+	c1 := &PolicySchema{rows: PolicyRows{row1}, rowNum: 0}
+	c2 := &PolicySchema{rows: PolicyRows{row2}, rowNum: 0}
+	expectStatements(t, c1.Add(),
+		`CREATE POLICY tenant_isolation ON app.account FOR ALL TO app_user, "Report Reader" USING ((tenant_id = current_setting('app.tenant_id'::text)::integer))`)
+	expectStatements(t, c1.Drop(), `DROP POLICY tenant_isolation ON app.account`)
+	expectStatements(t, c1.Change(c2),
+		`ALTER POLICY tenant_isolation ON app.account TO app_user, "Report Reader" USING ((tenant_id = current_setting('app.tenant_id'::text)::integer))`)
+
+	// A restrictive policy can't be altered into a permissive one
+	row2["permissive"], row2["roles"], row2["using_expr"] = "false", row1["roles"], row1["using_expr"]
+	expectStatements(t, c1.Change(c2),
+		`DROP POLICY tenant_isolation ON app.account`,
+		`CREATE POLICY tenant_isolation ON app.account FOR ALL TO app_user, "Report Reader" USING ((tenant_id = current_setting('app.tenant_id'::text)::integer))`)
+
+	// The policy is created on the table in the db2 schema
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "app", "app_test"
+	row1["command"], row1["permissive"], row1["using_expr"], row1["check_expr"] = "INSERT", "false", "null", "(app.is_valid(amount))"
+	expectStatements(t, c1.Add(),
+		`CREATE POLICY tenant_isolation ON app_test.account AS RESTRICTIVE FOR INSERT TO app_user, "Report Reader" WITH CHECK ((app_test.is_valid(amount)))`)
+
+	// The expressions are compared after the schema is remapped
+	row2 = map[string]string{"schema_name": "app_test", "table_name": "account", "policy_name": "tenant_isolation",
+		"command": "INSERT", "permissive": "false", "roles": row1["roles"], "using_expr": "null", "check_expr": "(app_test.is_valid(amount))"}
+	c2 = &PolicySchema{rows: PolicyRows{row2}, rowNum: 0}
+	if change := c1.Change(c2); !change.isEmpty() {
+		t.Errorf("Unexpected statements: %q", change.Statements)
+	}
+	row2["check_expr"] = "(amount > 0)"
+	expectStatements(t, c1.Change(c2),
+		`ALTER POLICY tenant_isolation ON app_test.account WITH CHECK ((app_test.is_valid(amount)))`)
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+}
+
+func Test_comparePoliciesRowSecurity(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	tables1 := []map[string]string{
+		{"table_schema": "app", "compare_name": "app.account", "table_name": "account", "row_security": "true", "force_row_security": "true"},
+		{"table_schema": "app", "compare_name": "app.invoice", "table_name": "invoice", "row_security": "true", "force_row_security": "false"},
+		{"table_schema": "app", "compare_name": "app.ledger", "table_name": "ledger", "row_security": "false", "force_row_security": "false"},
+	}
+	tables2 := []map[string]string{
+		{"table_schema": "app", "compare_name": "app.account", "table_name": "account", "row_security": "false", "force_row_security": "false"},
+		{"table_schema": "app", "compare_name": "app.audit", "table_name": "audit", "row_security": "true", "force_row_security": "false"},
+		{"table_schema": "app", "compare_name": "app.ledger", "table_name": "ledger", "row_security": "true", "force_row_security": "true"},
+	}
+	changes := comparePolicies(testCatalog{"TABLE": tables1}, testCatalog{"TABLE": tables2})
+	if len(changes) != 3 {
+		t.Fatalf("Expected changes for account, invoice, and ledger only: %v", changes)
+	}
+	expectStatements(t, changes[0],
+		"ALTER TABLE app.account ENABLE ROW LEVEL SECURITY",
+		"ALTER TABLE app.account FORCE ROW LEVEL SECURITY")
+	expectStatements(t, changes[1], "ALTER TABLE app.invoice ENABLE ROW LEVEL SECURITY")
+	expectStatements(t, changes[2],
+		"ALTER TABLE app.ledger DISABLE ROW LEVEL SECURITY",
+		"ALTER TABLE app.ledger NO FORCE ROW LEVEL SECURITY")
+}
@@ -0,0 +1,168 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// quote.go quotes identifiers and literals for the generated SQL
+//
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// plainIdentRegex matches an identifier that quote_ident() leaves unquoted.  Postgres
+// reads a $ in a name without quotes, but quote_ident() quotes it anyway.
+var plainIdentRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// keywords are the Postgres keywords that cannot be used as a name without quotes
+// (the reserved, type/function name, and column name keywords).  These are the words
+// that the quote_ident() function quotes.  The list below is replaced by the keywords
+// of the databases being compared (see loadKeywords).
+var keywords = initKeywords()
+
+var (
+	keywordSqlTemplate = initKeywordSqlTemplate()
+)
+
+// Initializes the Sql template.  Unreserved keywords can be used as names without quotes.
+func initKeywordSqlTemplate() *template.Template {
+	sql := `
+SELECT word
+FROM pg_catalog.pg_get_keywords()
+WHERE catcode <> 'U';
+`
+
+	t := template.New("KeywordSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// loadKeywords adds the keywords of both databases to the keyword set, so names are
+// quoted the same way pg_get_indexdef() and friends quote them on those servers.  It
+// keeps the built-in list when neither catalog returns any keywords.
+func loadKeywords(cat1 Catalog, cat2 Catalog) {
+	rows := append(cat1.Query("KEYWORD", keywordSqlTemplate), cat2.Query("KEYWORD", keywordSqlTemplate)...)
+	if len(rows) == 0 {
+		return
+	}
+	loaded := make(map[string]bool)
+	for _, row := range rows {
+		loaded[row["word"]] = true
+	}
+	keywords = loaded
+}
+
+// Initializes the keyword set
+func initKeywords() map[string]bool {
+	keywords := make(map[string]bool)
+	for _, word := range strings.Fields(`
+		all analyse analyze and any array as asc asymmetric both case cast check collate
+		column constraint create current_catalog current_date current_role current_time
+		current_timestamp current_user default deferrable desc distinct do else end except
+		false fetch for foreign from grant group having in initially intersect into lateral
+		leading limit localtime localtimestamp not null offset on only or order placing
+		primary references returning select session_user some symmetric table then to
+		trailing true union unique user using variadic when where window with
+
+		authorization binary collation concurrently cross current_schema freeze full ilike
+		inner is isnull join left like natural notnull outer overlaps right similar
+		tablesample verbose
+
+		between bigint bit boolean char character coalesce dec decimal exists extract float
+		greatest grouping inout int integer interval least national nchar none normalize
+		nullif numeric out overlay position precision real row setof smallint substring
+		time timestamp treat trim values varchar xmlattributes xmlconcat xmlelement
+		xmlexists xmlforest xmlnamespaces xmlparse xmlpi xmlroot xmlserialize xmltable`) {
+		keywords[word] = true
+	}
+	return keywords
+}
+
+// quoteIdent returns the identifier as it must be written in SQL.  Like Postgres'
+// quote_ident(), it only adds double quotes when they are needed (for upper case
+// letters, spaces, keywords, etc), so the SQL for ordinary names stays readable and
+// matches what pg_get_indexdef() and friends return.
+func quoteIdent(name string) string {
+	if plainIdentRegex.MatchString(name) && !keywords[name] {
+		return name
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// qualifiedName returns the schema-qualified name of an object with both parts quoted
+// as needed
+func qualifiedName(schema string, name string) string {
+	return quoteIdent(schema) + "." + quoteIdent(name)
+}
+
+// remapSchema changes the references to objects in one schema into references to the
+// same objects in another schema in an expression or definition from the catalog,
+// e.g. nextval('s1.id_seq'::regclass) or a column of type s1.status
+func remapSchema(def string, from string, to string) string {
+	if from == to {
+		return def
+	}
+	qualifier := regexp.MustCompile(`(^|[^a-zA-Z0-9_$"])` + regexp.QuoteMeta(quoteIdent(from)+"."))
+	return qualifier.ReplaceAllString(def, "${1}"+strings.Replace(quoteIdent(to), "$", "$$", -1)+".")
+}
+
+// quoteIdents quotes each identifier in a list and joins them with commas
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteLiteral returns the value as a SQL string literal.  Values with backslashes are
+// written as escape strings (E'...') so they mean the same thing whatever the
+// standard_conforming_strings setting is.
+func quoteLiteral(value string) string {
+	quoted := "'" + strings.Replace(value, "'", "''", -1) + "'"
+	if strings.Contains(value, `\`) {
+		return "E" + strings.Replace(quoted, `\`, `\\`, -1)
+	}
+	return quoted
+}
+
+// parseArray splits the text form of a Postgres array (like {a,"b c"}) into its
+// elements, removing the quotes and backslashes Postgres adds around elements with
+// special characters
+func parseArray(value string) []string {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") || !strings.HasSuffix(value, "}") {
+		return nil
+	}
+	value = value[1 : len(value)-1]
+
+	var elements []string
+	var element strings.Builder
+	inQuotes, quoted, escaped := false, false, false
+	for _, r := range value {
+		switch {
+		case escaped:
+			element.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case r == ',' && !inQuotes:
+			elements = append(elements, element.String())
+			element.Reset()
+			quoted = false
+		default:
+			element.WriteRune(r)
+		}
+	}
+	if element.Len() > 0 || quoted || len(elements) > 0 {
+		elements = append(elements, element.String())
+	}
+	return elements
+}
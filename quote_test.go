@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_quoteIdent(t *testing.T) {
+	tests := map[string]string{
+		"account":    "account",
+		"_id2":       "_id2",
+		"user":       `"user"`,
+		"order":      `"order"`,
+		"MixedCase":  `"MixedCase"`,
+		"has space":  `"has space"`,
+		"2fast":      `"2fast"`,
+		"price$":     `"price$"`,
+		`say "what"`: `"say ""what"""`,
+	}
+	for name, expected := range tests {
+		if quoted := quoteIdent(name); quoted != expected {
+			t.Errorf("quoteIdent(%q) is %s instead of %s", name, quoted, expected)
+		}
+	}
+	if name := qualifiedName("Sales", "order"); name != `"Sales"."order"` {
+		t.Errorf("Wrong qualified name: %s", name)
+	}
+}
+
+func Test_loadKeywords(t *testing.T) {
+	saved := keywords
+	defer func() { keywords = saved }()
+
+	loadKeywords(testCatalog{}, testCatalog{})
+	if quoted := quoteIdent("user"); quoted != `"user"` {
+		t.Errorf("The built-in keywords should be kept without a KEYWORD query: %s", quoted)
+	}
+
+	loadKeywords(testCatalog{"KEYWORD": {{"word": "system_user"}}}, testCatalog{"KEYWORD": {{"word": "json_table"}}})
+	for _, name := range []string{"system_user", "json_table"} {
+		if quoted := quoteIdent(name); quoted != `"`+name+`"` {
+			t.Errorf("quoteIdent(%q) is %s", name, quoted)
+		}
+	}
+}
+
+func Test_quoteLiteral(t *testing.T) {
+	tests := map[string]string{
+		"infinity":   "'infinity'",
+		"O'Brien":    "'O''Brien'",
+		`C:\temp\'x`: `E'C:\\temp\\''x'`,
+	}
+	for value, expected := range tests {
+		if quoted := quoteLiteral(value); quoted != expected {
+			t.Errorf("quoteLiteral(%q) is %s instead of %s", value, quoted, expected)
+		}
+	}
+}
+
+func Test_parseArray(t *testing.T) {
+	tests := map[string][]string{
+		"{}":                           nil,
+		"{admin}":                      {"admin"},
+		`{admin,"Mixed Role","a,b"}`:   {"admin", "Mixed Role", "a,b"},
+		`{"say \"hi\"","back\\slash"}`: {`say "hi"`, `back\slash`},
+	}
+	for value, expected := range tests {
+		elements := parseArray(value)
+		if len(elements) != len(expected) {
+			t.Errorf("parseArray(%q) is %q instead of %q", value, elements, expected)
+			continue
+		}
+		for i := range elements {
+			if elements[i] != expected[i] {
+				t.Errorf("parseArray(%q) is %q instead of %q", value, elements, expected)
+			}
+		}
+	}
+}
+
+// Test_quotedGenerators checks the SQL generated for names that need quotes
+func Test_quotedGenerators(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+
+	column := &ColumnSchema{rows: ColumnRows{
+		{"table_schema": "Sales", "table_name": "order", "column_name": "user", "data_type": "integer",
+			"is_nullable": "YES", "column_default": "null", "is_identity": "NO"},
+	}, rowNum: 0}
+	expectStatements(t, column.Add(), `ALTER TABLE "Sales"."order" ADD COLUMN "user" integer`)
+	expectStatements(t, column.Drop(), `ALTER TABLE "Sales"."order" DROP COLUMN IF EXISTS "user"`)
+
+	table := &TableSchema{rows: TableRows{
+		{"table_schema": "public", "table_name": "Line Item", "table_type": "TABLE"},
+	}, rowNum: 0}
+	expectStatements(t, table.Add(), `CREATE TABLE public."Line Item" ()`)
+
+	index := &IndexSchema{rows: IndexRows{
+		{"schema_name": "public", "table_name": "user", "index_name": "User_pkey", "pk": "true", "uq": "true",
+			"index_def": `CREATE UNIQUE INDEX "User_pkey" ON public."user" USING btree (id)`, "constraint_def": "PRIMARY KEY (id)"},
+	}, rowNum: 0}
+	expectStatements(t, index.Drop(),
+		`ALTER TABLE public."user" DROP CONSTRAINT "User_pkey" CASCADE`,
+		`DROP INDEX public."User_pkey"`)
+
+	role := &RoleSchema{rows: RoleRows{
+		{"rolname": "Report Reader", "rolcanlogin": "false", "rolinherit": "true", "rolreplication": "false",
+			"rolconnlimit": "-1", "rolvaliduntil": "null"},
+	}, rowNum: 0}
+	expectStatements(t, role.Drop(), `DROP ROLE "Report Reader"`)
+
+	grant := &GrantRelationshipSchema{rows: GrantRelationshipRows{
+		{"schema_name": "public", "relationship_name": "order", "type": "TABLE", "relationship_acl": `"Report Reader"=r/postgres`},
+	}, rowNum: 0}
+	expectStatements(t, grant.Add(), `GRANT SELECT ON public."order" TO "Report Reader"`)
+}
+
+func expectStatements(t *testing.T, change *SchemaChange, expected ...string) {
+	if len(change.Statements) != len(expected) {
+		t.Errorf("Wrong statements: %q instead of %q", change.Statements, expected)
+		return
+	}
+	for i := range expected {
+		if change.Statements[i] != expected[i] {
+			t.Errorf("Wrong statement: %s instead of %s", change.Statements[i], expected[i])
+		}
+	}
+}
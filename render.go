@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// render.go prints change records in the formats pgdiff supports
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The output formats given to --format
+const (
+	FormatSql  = "sql"
+	FormatJson = "json"
+	FormatYaml = "yaml"
+)
+
+// render prints the changes in the given format
+func render(w io.Writer, format string, changes []*SchemaChange) {
+	switch format {
+	case FormatJson:
+		renderJson(w, changes)
+	case FormatYaml:
+		renderYaml(w, changes)
+	default:
+		renderSql(w, changes)
+	}
+}
+
+// renderSql prints the changes as a SQL script to be run against db2.  Warnings are
+// printed as comments before the statements they apply to.  Statements that span
+// lines or contain semicolons (like function bodies) are wrapped in STATEMENT-BEGIN
+// and STATEMENT-END comments so that tools can split the script correctly.
+func renderSql(w io.Writer, changes []*SchemaChange) {
+	for _, change := range changes {
+		for _, warning := range change.Warnings {
+			fmt.Fprintf(w, "-- %s\n", warning)
+		}
+		for _, stmt := range change.Statements {
+			// Grant statements have always been labeled with what caused them
+			label := ""
+			if strings.HasPrefix(change.Kind, "GRANT") {
+				label = " -- " + change.Action[:1] + strings.ToLower(change.Action[1:])
+			}
+
+			if strings.ContainsAny(stmt, "\n;") {
+				fmt.Fprintln(w, "-- STATEMENT-BEGIN")
+				fmt.Fprintf(w, "%s;%s\n", stmt, label)
+				fmt.Fprintln(w, "-- STATEMENT-END")
+			} else {
+				fmt.Fprintf(w, "%s;%s\n", stmt, label)
+			}
+		}
+	}
+}
+
+// renderSummary prints one line for each object that differs, followed by a count,
+// for the --check drift mode
+func renderSummary(w io.Writer, changes []*SchemaChange) {
+	for _, change := range changes {
+		fmt.Fprintf(w, "%-6s %-18s %s\n", change.Action, change.Kind, change.Name)
+	}
+	switch len(changes) {
+	case 0:
+		fmt.Fprintln(w, "No differences found")
+	case 1:
+		fmt.Fprintln(w, "1 difference found")
+	default:
+		fmt.Fprintf(w, "%d differences found\n", len(changes))
+	}
+}
+
+// renderJson prints the changes as a JSON array with one record per difference.
+// Empty lists are printed as [] rather than null so consumers don't need to check.
+func renderJson(w io.Writer, changes []*SchemaChange) {
+	records := make([]SchemaChange, len(changes))
+	for i, change := range changes {
+		records[i] = *change
+		if records[i].Statements == nil {
+			records[i].Statements = []string{}
+		}
+		if records[i].Warnings == nil {
+			records[i].Warnings = []string{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(records)
+}
+
+// renderYaml prints the same records as renderJson, as a YAML sequence.  Every string
+// is written as a double-quoted scalar, which YAML reads the same way JSON does, so
+// SQL containing colons, quotes, or newlines needs no special handling.
+func renderYaml(w io.Writer, changes []*SchemaChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "[]")
+		return
+	}
+	for _, change := range changes {
+		fmt.Fprintf(w, "- type: %s\n", yamlString(change.Kind))
+		fmt.Fprintf(w, "  schema: %s\n", yamlString(change.Schema))
+		fmt.Fprintf(w, "  object: %s\n", yamlString(change.Name))
+		fmt.Fprintf(w, "  action: %s\n", yamlString(change.Action))
+		yamlMap(w, "db1", change.DB1)
+		yamlMap(w, "db2", change.DB2)
+		yamlList(w, "sql", change.Statements)
+		yamlList(w, "warnings", change.Warnings)
+		fmt.Fprintf(w, "  destructive: %t\n", change.Destructive)
+	}
+}
+
+// yamlMap prints a catalog row as a nested mapping with sorted keys
+func yamlMap(w io.Writer, name string, row map[string]string) {
+	if row == nil {
+		fmt.Fprintf(w, "  %s: null\n", name)
+		return
+	}
+	if len(row) == 0 {
+		fmt.Fprintf(w, "  %s: {}\n", name)
+		return
+	}
+	keys := make([]string, 0, len(row))
+	for key := range row {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "  %s:\n", name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "    %s: %s\n", yamlString(key), yamlString(row[key]))
+	}
+}
+
+// yamlList prints a list of strings as a nested sequence
+func yamlList(w io.Writer, name string, list []string) {
+	if len(list) == 0 {
+		fmt.Fprintf(w, "  %s: []\n", name)
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", name)
+	for _, item := range list {
+		fmt.Fprintf(w, "    - %s\n", yamlString(item))
+	}
+}
+
+// yamlString quotes a string the way JSON does, which is also valid YAML
+func yamlString(s string) string {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+func Test_renderSql(t *testing.T) {
+	changes := []*SchemaChange{
+		{Kind: "FUNCTION", Action: ActionChange, Warnings: []string{"This function is different"},
+			Statements: []string{"CREATE OR REPLACE FUNCTION s1.f1()\n AS $$ SELECT 1; $$"}},
+		{Kind: "GRANT_RELATIONSHIP", Action: ActionAdd, Statements: []string{"GRANT SELECT ON s1.t1 TO u1"}},
+		{Kind: "TABLE", Action: ActionDrop, Statements: []string{"DROP TABLE s1.t2"}},
+	}
+	expected := `-- This function is different
+-- STATEMENT-BEGIN
+CREATE OR REPLACE FUNCTION s1.f1()
+ AS $$ SELECT 1; $$;
+-- STATEMENT-END
+GRANT SELECT ON s1.t1 TO u1; -- Add
+DROP TABLE s1.t2;
+`
+	buf := new(bytes.Buffer)
+	renderSql(buf, changes)
+	if buf.String() != expected {
+		t.Errorf("Wrong SQL rendered:\n%s\ninstead of:\n%s", buf.String(), expected)
+	}
+}
+
+func Test_renderJson(t *testing.T) {
+	changes := []*SchemaChange{
+		{Kind: "TABLE", Schema: "s1", Name: "s1.t1", Action: ActionAdd,
+			DB1: map[string]string{"table_name": "t1"}, Statements: []string{"CREATE TABLE s1.t1()"}},
+	}
+	buf := new(bytes.Buffer)
+	renderJson(buf, changes)
+
+	var records []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Invalid JSON rendered: %v\n%s", err, buf.String())
+	}
+	if len(records) != 1 || records[0]["type"] != "TABLE" || records[0]["object"] != "s1.t1" || records[0]["db2"] != nil {
+		t.Errorf("Wrong JSON rendered:\n%s", buf.String())
+	}
+	if warnings, ok := records[0]["warnings"].([]interface{}); !ok || len(warnings) != 0 {
+		t.Errorf("Warnings should be an empty list:\n%s", buf.String())
+	}
+}
+
+// Comparing must not print anything but the rendered changes on stdout
+func Test_renderJsonStdout(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	index := map[string]string{"compare_name": "s1.t1.t1_name_idx", "schema_name": "s1", "table_name": "t1",
+		"index_name": "t1_name_idx", "pk": "false", "uq": "false", "partitioned": "false", "constraint_def": "null",
+		"index_def": "CREATE INDEX t1_name_idx ON s1.t1 USING btree (name)", "parent_index": "null"}
+	tables := []map[string]string{{"table_schema": "s1", "compare_name": "s1.t1", "table_name": "t1", "table_type": "TABLE"}}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	changes := compareIndexes(testCatalog{"TABLE": tables}, testCatalog{"TABLE": tables, "INDEX": {index}})
+	render(os.Stdout, FormatJson, changes)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+
+	var records []map[string]interface{}
+	if err := json.Unmarshal(out, &records); err != nil {
+		t.Fatalf("Invalid JSON rendered: %v\n%s", err, out)
+	}
+	if len(records) != 1 || records[0]["action"] != ActionDrop {
+		t.Errorf("Wrong JSON rendered:\n%s", out)
+	}
+}
+
+func Test_renderYaml(t *testing.T) {
+	changes := []*SchemaChange{
+		{Kind: "FUNCTION", Schema: "s1", Name: "s1.f1", Action: ActionChange,
+			DB1: map[string]string{"schema_name": "s1", "definition": "SELECT 'a: b';\n"}, DB2: map[string]string{},
+			Statements: []string{"CREATE FUNCTION s1.f1()"}, Warnings: []string{"Check this"}},
+	}
+	expected := `- type: "FUNCTION"
+  schema: "s1"
+  object: "s1.f1"
+  action: "CHANGE"
+  db1:
+    "definition": "SELECT 'a: b';\n"
+    "schema_name": "s1"
+  db2: {}
+  sql:
+    - "CREATE FUNCTION s1.f1()"
+  warnings:
+    - "Check this"
+  destructive: false
+`
+	buf := new(bytes.Buffer)
+	renderYaml(buf, changes)
+	if buf.String() != expected {
+		t.Errorf("Wrong YAML rendered:\n%s\ninstead of:\n%s", buf.String(), expected)
+	}
+}
+
+func Test_renderSummary(t *testing.T) {
+	changes := []*SchemaChange{
+		{Kind: "TABLE", Name: "s1.t1", Action: ActionAdd, Statements: []string{"CREATE TABLE s1.t1()"}},
+		{Kind: "COLUMN", Name: "s1.t2.c1", Action: ActionChange, Statements: []string{"ALTER TABLE s1.t2 ALTER COLUMN c1 TYPE text"}},
+	}
+	expected := `ADD    TABLE              s1.t1
+CHANGE COLUMN             s1.t2.c1
+2 differences found
+`
+	buf := new(bytes.Buffer)
+	renderSummary(buf, changes)
+	if buf.String() != expected {
+		t.Errorf("Wrong summary rendered:\n%s\ninstead of:\n%s", buf.String(), expected)
+	}
+}
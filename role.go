@@ -7,17 +7,13 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
-	"regexp"
+	"os"
 	"sort"
-	"strings"
+	"text/template"
 )
 
-var curlyBracketRegex = regexp.MustCompile("[{}]")
-
 // RoleRows is a sortable slice of string maps
 type RoleRows []map[string]string
 
@@ -33,6 +29,35 @@ func (slice RoleRows) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+var (
+	roleSqlTemplate = initRoleSqlTemplate()
+)
+
+// Initializes the Sql template
+func initRoleSqlTemplate() *template.Template {
+	sql := `
+SELECT r.rolname
+    , r.rolsuper
+    , r.rolinherit
+    , r.rolcreaterole
+    , r.rolcreatedb
+    , r.rolcanlogin
+    , r.rolconnlimit
+    , r.rolvaliduntil
+    , r.rolreplication
+	, ARRAY(SELECT b.rolname 
+	        FROM pg_catalog.pg_auth_members m  
+			JOIN pg_catalog.pg_roles b ON (m.roleid = b.oid)  
+	        WHERE m.member = r.oid
+	        ORDER BY b.rolname) as memberof
+FROM pg_catalog.pg_roles AS r
+ORDER BY r.rolname;
+`
+	t := template.New("RoleSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
 // ==================================
 // RoleSchema definition
 // (implements Schema -- defined in pgdiff.go)
@@ -75,7 +100,7 @@ func (c *RoleSchema) NextRow() bool {
 func (c *RoleSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*RoleSchema)
 	if !ok {
-		fmt.Println("Error!!!, change needs a RoleSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, change needs a RoleSchema instance", c2)
 		return +999
 	}
 
@@ -83,6 +108,19 @@ func (c *RoleSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the role in the current row when ordering by dependency
+func (c *RoleSchema) ObjectKey() string {
+	return "role:" + c.get("rolname")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *RoleSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 /*
 CREATE ROLE name [ [ WITH ] option [ ... ] ]
 
@@ -107,7 +145,8 @@ where option can be:
 */
 
 // Add generates SQL to add the constraint/index
-func (c RoleSchema) Add() {
+func (c RoleSchema) Add() *SchemaChange {
+	change := newChange("ROLE", ActionAdd, "", c.get("rolname"))
 
 	// We don't care about efficiency here so we just concat strings
 	options := " WITH PASSWORD 'changeme'"
@@ -146,23 +185,28 @@ func (c RoleSchema) Add() {
 		options += " CONNECTION LIMIT " + c.get("rolconnlimit")
 	}
 	if c.get("rolvaliduntil") != "null" {
-		options += fmt.Sprintf(" VALID UNTIL '%s'", c.get("rolvaliduntil"))
+		options += " VALID UNTIL " + quoteLiteral(c.get("rolvaliduntil"))
 	}
 
-	fmt.Printf("CREATE ROLE %s%s;\n", c.get("rolname"), options)
+	change.addSql("CREATE ROLE %s%s", quoteIdent(c.get("rolname")), options)
+	return change
 }
 
 // Drop generates SQL to drop the role
-func (c RoleSchema) Drop() {
-	fmt.Printf("DROP ROLE %s;\n", c.get("rolname"))
+func (c RoleSchema) Drop() *SchemaChange {
+	change := newChange("ROLE", ActionDrop, "", c.get("rolname"))
+	change.Destructive = true
+	change.addSql("DROP ROLE %s", quoteIdent(c.get("rolname")))
+	return change
 }
 
 // Change handles the case where the role name matches, but the details do not
-func (c RoleSchema) Change(obj interface{}) {
+func (c RoleSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*RoleSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a RoleSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a RoleSchema instance", c2)
 	}
+	change := newChange("ROLE", ActionChange, "", c.get("rolname"))
 
 	options := ""
 	if c.get("rolsuper") != c2.get("rolsuper") {
@@ -229,76 +273,46 @@ func (c RoleSchema) Change(obj interface{}) {
 
 	if c.get("rolvaliduntil") != c2.get("rolvaliduntil") {
 		if c.get("rolvaliduntil") != "null" {
-			options += fmt.Sprintf(" VALID UNTIL '%s'", c.get("rolvaliduntil"))
+			options += " VALID UNTIL " + quoteLiteral(c.get("rolvaliduntil"))
 		}
 	}
 
 	// Only alter if we have changes
 	if len(options) > 0 {
-		fmt.Printf("ALTER ROLE %s%s;\n", c.get("rolname"), options)
+		change.addSql("ALTER ROLE %s%s", quoteIdent(c.get("rolname")), options)
 	}
 
 	if c.get("memberof") != c2.get("memberof") {
-		fmt.Println(c.get("memberof"), "!=", c2.get("memberof"))
+		change.addWarning("%s != %s", c.get("memberof"), c2.get("memberof"))
 
-		// Remove the curly brackets
-		memberof1 := curlyBracketRegex.ReplaceAllString(c.get("memberof"), "")
-		memberof2 := curlyBracketRegex.ReplaceAllString(c2.get("memberof"), "")
-
-		// Split
-		membersof1 := strings.Split(memberof1, ",")
-		membersof2 := strings.Split(memberof2, ",")
+		membersof1 := parseArray(c.get("memberof"))
+		membersof2 := parseArray(c2.get("memberof"))
 
 		// TODO: Define INHERIT or not
 		for _, mo1 := range membersof1 {
 			if !misc.ContainsString(membersof2, mo1) {
-				fmt.Printf("GRANT %s TO %s;\n", mo1, c.get("rolname"))
+				change.addSql("GRANT %s TO %s", quoteIdent(mo1), quoteIdent(c.get("rolname")))
 			}
 		}
 
 		for _, mo2 := range membersof2 {
 			if !misc.ContainsString(membersof1, mo2) {
-				fmt.Printf("REVOKE %s FROM %s;\n", mo2, c.get("rolname"))
+				change.addSql("REVOKE %s FROM %s", quoteIdent(mo2), quoteIdent(c.get("rolname")))
 			}
 		}
 
 	}
+	return change
 }
 
 /*
  * Compare the roles between two databases or schemas
  */
-func compareRoles(conn1 *sql.DB, conn2 *sql.DB) {
-	sql := `
-SELECT r.rolname
-    , r.rolsuper
-    , r.rolinherit
-    , r.rolcreaterole
-    , r.rolcreatedb
-    , r.rolcanlogin
-    , r.rolconnlimit
-    , r.rolvaliduntil
-    , r.rolreplication
-	, ARRAY(SELECT b.rolname 
-	        FROM pg_catalog.pg_auth_members m  
-			JOIN pg_catalog.pg_roles b ON (m.roleid = b.oid)  
-	        WHERE m.member = r.oid) as memberof
-FROM pg_catalog.pg_roles AS r
-ORDER BY r.rolname;
-`
-	rowChan1, _ := pgutil.QueryStrings(conn1, sql)
-	rowChan2, _ := pgutil.QueryStrings(conn2, sql)
-
-	rows1 := make(RoleRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+func compareRoles(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	rows1 := RoleRows(cat1.Query("ROLE", roleSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(RoleRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := RoleRows(cat2.Query("ROLE", roleSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here for some unknown reason
@@ -306,5 +320,5 @@ ORDER BY r.rolname;
 	var schema2 Schema = &RoleSchema{rows: rows2, rowNum: -1}
 
 	// Compare the roles
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
@@ -7,10 +7,29 @@
 package main
 
 import "fmt"
+import "os"
 import "sort"
-import "database/sql"
-import "github.com/joncrlsn/pgutil"
 import "github.com/joncrlsn/misc"
+import "text/template"
+
+var (
+	schemataSqlTemplate = initSchemataSqlTemplate()
+)
+
+// Initializes the Sql template
+func initSchemataSqlTemplate() *template.Template {
+	sql := `
+SELECT schema_name
+    , schema_owner
+    , default_character_set_schema
+FROM information_schema.schemata
+WHERE {{$.SchemaFilter "schema_name"}}
+AND {{$.ExtensionFilter "pg_namespace" "(SELECT oid FROM pg_catalog.pg_namespace WHERE nspname = schema_name)"}}
+ORDER BY schema_name;`
+	t := template.New("SchemataSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
 
 // ==================================
 // SchemataRows definition
@@ -62,7 +81,7 @@ func (c *SchemataSchema) NextRow() bool {
 func (c *SchemataSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*SchemataSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a SchemataSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a SchemataSchema instance", c2)
 		return +999
 	}
 
@@ -71,59 +90,59 @@ func (c *SchemataSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the schema in the current row when ordering by dependency
+func (c *SchemataSchema) ObjectKey() string {
+	return "schema:" + c.get("schema_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *SchemataSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to add the schemata
-func (c SchemataSchema) Add() {
+func (c SchemataSchema) Add() *SchemaChange {
+	change := newChange("SCHEMA", ActionAdd, "", c.get("schema_name"))
 	// CREATE SCHEMA schema_name [ AUTHORIZATION user_name
-	fmt.Printf("CREATE SCHEMA %s AUTHORIZATION %s;", c.get("schema_name"), c.get("schema_owner"))
-	fmt.Println()
+	change.addSql("CREATE SCHEMA %s AUTHORIZATION %s", quoteIdent(c.get("schema_name")), quoteIdent(c.get("schema_owner")))
+	return change
 }
 
 // Drop returns SQL to drop the schemata
-func (c SchemataSchema) Drop() {
+func (c SchemataSchema) Drop() *SchemaChange {
+	change := newChange("SCHEMA", ActionDrop, "", c.get("schema_name"))
+	change.Destructive = true
 	// DROP SCHEMA [ IF EXISTS ] name [, ...] [ CASCADE | RESTRICT ]
-	fmt.Printf("DROP SCHEMA IF EXISTS %s;\n", c.get("schema_name"))
+	change.addSql("DROP SCHEMA IF EXISTS %s", quoteIdent(c.get("schema_name")))
+	return change
 }
 
 // Change handles the case where the schema name matches, but the details do not
-func (c SchemataSchema) Change(obj interface{}) {
+func (c SchemataSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*SchemataSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a SchemataSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a SchemataSchema instance", c2)
 	}
 	// There's nothing we need to do here
+	return nil
 }
 
-// compareSchematas outputs SQL to make the schema names match between DBs
-func compareSchematas(conn1 *sql.DB, conn2 *sql.DB) {
+// compareSchematas returns the changes needed to make the schema names match between DBs
+func compareSchematas(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
 	// if we are comparing two schemas against each other, then
 	// we won't compare to ensure they are created, although maybe we should.
 	if dbInfo1.DbSchema != dbInfo2.DbSchema {
-		return
+		return nil
 	}
 
-	sql := `
-SELECT schema_name
-    , schema_owner
-    , default_character_set_schema
-FROM information_schema.schemata
-WHERE schema_name NOT LIKE 'pg_%' 
-  AND schema_name <> 'information_schema' 
-ORDER BY schema_name;`
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, sql)
-	rowChan2, _ := pgutil.QueryStrings(conn2, sql)
-
-	rows1 := make(SchemataRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := SchemataRows(cat1.Query("SCHEMA", schemataSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(SchemataRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := SchemataRows(cat2.Query("SCHEMA", schemataSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here
@@ -131,5 +150,5 @@ ORDER BY schema_name;`
 	var schema2 Schema = &SchemataSchema{rows: rows2, rowNum: -1}
 
 	// Compare the schematas
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
@@ -7,39 +7,51 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
 var (
-	sequenceSqlTemplate = initSequenceSqlTemplate()
+	sequenceSqlTemplate      = initSequenceSqlTemplate()
+	sequenceValueSqlTemplate = initSequenceValueSqlTemplate()
 )
 
-// Initializes the Sql template
+// Initializes the Sql template.  pg_sequence is new in PostgreSQL 10, which is older
+// than the oldest server pgdiff supports (see minServerVersion).
 func initSequenceSqlTemplate() *template.Template {
 	sql := `
-SELECT sequence_schema AS schema_name
-    , {{if eq $.DbSchema "*" }}sequence_schema || '.' || {{end}}sequence_name AS compare_name
-    , sequence_name 
-	, data_type
-	, start_value
-	, minimum_value
-	, maximum_value
-	, increment
-	, cycle_option 
-FROM information_schema.sequences
-WHERE true
-{{if eq $.DbSchema "*" }}
-AND sequence_schema NOT LIKE 'pg_%' 
-AND sequence_schema <> 'information_schema' 
-{{else}}
-AND sequence_schema = '{{$.DbSchema}}'
-{{end}}
+SELECT n.nspname AS schema_name
+    , {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}c.relname AS compare_name
+    , c.relname AS sequence_name
+    , pg_catalog.format_type(s.seqtypid, NULL) AS data_type
+    , s.seqstart AS start_value
+    , s.seqmin AS minimum_value
+    , s.seqmax AS maximum_value
+    , s.seqincrement AS increment
+    , s.seqcache AS cache_size
+    , s.seqcycle AS cycle
+    -- The column the sequence is OWNED BY
+    , tn.nspname AS owned_by_schema
+    , tc.relname AS owned_by_table
+    , a.attname AS owned_by_column
+FROM pg_catalog.pg_sequence AS s
+INNER JOIN pg_catalog.pg_class AS c ON (c.oid = s.seqrelid)
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+LEFT OUTER JOIN pg_catalog.pg_depend AS d ON (d.classid = 'pg_class'::regclass AND d.objid = c.oid
+    AND d.refclassid = 'pg_class'::regclass AND d.refobjsubid > 0 AND d.deptype = 'a')
+LEFT OUTER JOIN pg_catalog.pg_class AS tc ON (tc.oid = d.refobjid)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS tn ON (tn.oid = tc.relnamespace)
+LEFT OUTER JOIN pg_catalog.pg_attribute AS a ON (a.attrelid = d.refobjid AND a.attnum = d.refobjsubid)
+WHERE {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+-- Leave out the sequences of identity columns, which belong to the column (see table.go)
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend AS i
+    WHERE i.classid = 'pg_class'::regclass AND i.objid = c.oid AND i.deptype = 'i')
 `
 
 	t := template.New("SequenceSqlTmpl")
@@ -47,6 +59,26 @@ AND sequence_schema = '{{$.DbSchema}}'
 	return t
 }
 
+// Initializes the Sql template for the current value of each sequence, which is only
+// read for --sync-sequence-values.  It isn't one of the catalogQueries, so snapshots
+// don't change every time a row is inserted.  The value is null when nextval has never
+// been called or we can't read the sequence.
+func initSequenceValueSqlTemplate() *template.Template {
+	sql := `
+SELECT {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}c.relname AS compare_name
+    , CASE WHEN pg_catalog.has_sequence_privilege(c.oid, 'SELECT,USAGE')
+      THEN pg_catalog.pg_sequence_last_value(c.oid) END AS last_value
+FROM pg_catalog.pg_class AS c
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = c.relnamespace)
+WHERE c.relkind = 'S'
+AND {{$.SchemaFilter "n.nspname"}}
+`
+
+	t := template.New("SequenceValueSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
 // ==================================
 // SequenceRows definition
 // ==================================
@@ -97,7 +129,7 @@ func (c *SequenceSchema) NextRow() bool {
 func (c *SequenceSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*SequenceSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a SequenceSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a SequenceSchema instance", c2)
 		return +999
 	}
 
@@ -105,57 +137,237 @@ func (c *SequenceSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the sequence in the current row when ordering by dependency
+func (c *SequenceSchema) ObjectKey() string {
+	return "sequence:" + c.get("schema_name") + "." + c.get("sequence_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *SequenceSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to add the sequence
-func (c SequenceSchema) Add() {
+func (c SequenceSchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("schema_name")
 	}
-	fmt.Printf("CREATE SEQUENCE %s.%s INCREMENT %s MINVALUE %s MAXVALUE %s START %s;\n", schema, c.get("sequence_name"), c.get("increment"), c.get("minimum_value"), c.get("maximum_value"), c.get("start_value"))
+	change := newChange("SEQUENCE", ActionAdd, schema, c.get("sequence_name"))
+	cycle := "NO CYCLE"
+	if c.get("cycle") == "true" {
+		cycle = "CYCLE"
+	}
+	change.addSql("CREATE SEQUENCE %s AS %s INCREMENT BY %s MINVALUE %s MAXVALUE %s START WITH %s CACHE %s %s", qualifiedName(schema, c.get("sequence_name")),
+		c.get("data_type"), c.get("increment"), c.get("minimum_value"), c.get("maximum_value"), c.get("start_value"), c.get("cache_size"), cycle)
+	if syncSequenceValues {
+		c.addSetval(change, schema, "null")
+	}
+	return change
+}
+
+// addSetval adds the SQL to move the sequence in the given schema to its db1 value
+// when --sync-sequence-values is on.  The sequence never moves backwards: nothing is
+// done when db2 has already handed out that value (lastValue2 is db2's current value,
+// or null when nextval has never been called there).
+func (c *SequenceSchema) addSetval(change *SchemaChange, schema string, lastValue2 string) {
+	value1, err := strconv.ParseInt(c.get("last_value"), 10, 64)
+	if err != nil {
+		// nextval has never been called in db1 (or we can't read the sequence)
+		return
+	}
+	if value2, err := strconv.ParseInt(lastValue2, 10, 64); err == nil {
+		descending := strings.HasPrefix(c.get("increment"), "-")
+		if (!descending && value1 <= value2) || (descending && value1 >= value2) {
+			return
+		}
+	}
+	change.addSql("SELECT pg_catalog.setval(%s, %d, true)", quoteLiteral(qualifiedName(schema, c.get("sequence_name"))), value1)
 }
 
 // Drop returns SQL to drop the sequence
-func (c SequenceSchema) Drop() {
-	fmt.Printf("DROP SEQUENCE %s.%s;\n", c.get("schema_name"), c.get("sequence_name"))
+func (c SequenceSchema) Drop() *SchemaChange {
+	change := newChange("SEQUENCE", ActionDrop, c.get("schema_name"), c.get("sequence_name"))
+	change.Destructive = true
+	change.addSql("DROP SEQUENCE %s", qualifiedName(c.get("schema_name"), c.get("sequence_name")))
+	return change
 }
 
-// Change doesn't do anything right now.
-func (c SequenceSchema) Change(obj interface{}) {
+// Change alters the data type, increment, limits, start, cache, and cycling of the
+// sequence when they are different
+func (c SequenceSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*SequenceSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change(obj) needs a SequenceSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change(obj) needs a SequenceSchema instance", c2)
+	}
+	change := newChange("SEQUENCE", ActionChange, c2.get("schema_name"), c.get("sequence_name"))
+
+	// The data type comes first because it changes the default limits
+	var options []string
+	if c.get("data_type") != c2.get("data_type") {
+		options = append(options, "AS "+c.get("data_type"))
+	}
+	for _, option := range []struct{ column, clause string }{
+		{"increment", "INCREMENT BY"},
+		{"minimum_value", "MINVALUE"},
+		{"maximum_value", "MAXVALUE"},
+		{"start_value", "START WITH"},
+		{"cache_size", "CACHE"},
+	} {
+		if c.get(option.column) != c2.get(option.column) {
+			options = append(options, option.clause+" "+c.get(option.column))
+		}
+	}
+	if c.get("cycle") != c2.get("cycle") {
+		if c.get("cycle") == "true" {
+			options = append(options, "CYCLE")
+		} else {
+			options = append(options, "NO CYCLE")
+		}
 	}
-	// Don't know of anything helpful we should do here
+	if len(options) > 0 {
+		change.addSql("ALTER SEQUENCE %s %s", qualifiedName(c2.get("schema_name"), c2.get("sequence_name")), strings.Join(options, " "))
+	}
+	if syncSequenceValues {
+		c.addSetval(change, c2.get("schema_name"), c2.get("last_value"))
+	}
+	return change
 }
 
-// compareSequences outputs SQL to make the sequences match between DBs or schemas
-func compareSequences(conn1 *sql.DB, conn2 *sql.DB) {
+// ==================================
+// SequenceOwnerSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
 
-	buf1 := new(bytes.Buffer)
-	sequenceSqlTemplate.Execute(buf1, dbInfo1)
+// SequenceOwnerSchema compares the column that each sequence is OWNED BY.  Its
+// changes are keyed as the owning table, because the table must exist before the
+// sequence can be owned by it (and the table's column defaults need the sequence
+// first, so this can't be done when the sequence is created).  They have a kind of
+// their own, SEQUENCE_OWNER, so a new sequence is not counted as two changes.
+type SequenceOwnerSchema struct {
+	SequenceSchema
+}
 
-	buf2 := new(bytes.Buffer)
-	sequenceSqlTemplate.Execute(buf2, dbInfo2)
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *SequenceOwnerSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*SequenceOwnerSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a SequenceOwnerSchema instance", c2)
+		return +999
+	}
+	return c.SequenceSchema.Compare(&c2.SequenceSchema)
+}
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
+// ObjectKey identifies the table that owns the sequence, or the sequence when it
+// isn't owned by a column
+func (c *SequenceOwnerSchema) ObjectKey() string {
+	if c.get("owned_by_table") == "null" {
+		return c.SequenceSchema.ObjectKey()
+	}
+	return "table:" + c.get("owned_by_schema") + "." + c.get("owned_by_table")
+}
 
-	rows1 := make(SequenceRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
+// ownedBy returns the column the sequence is owned by, or NONE.  An owning table in
+// the sequence's own schema is assumed to be in the given schema.
+func (c *SequenceOwnerSchema) ownedBy(schema string) string {
+	if c.get("owned_by_table") == "null" {
+		return "NONE"
 	}
-	sort.Sort(rows1)
+	if c.get("owned_by_schema") != c.get("schema_name") {
+		schema = c.get("owned_by_schema")
+	}
+	return qualifiedName(schema, c.get("owned_by_table")) + "." + quoteIdent(c.get("owned_by_column"))
+}
 
-	rows2 := make(SequenceRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
+// Add returns SQL to make a new sequence owned by its column
+func (c *SequenceOwnerSchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("schema_name")
+	}
+	change := newChange("SEQUENCE_OWNER", ActionAdd, schema, c.get("sequence_name"))
+	if c.get("owned_by_table") != "null" {
+		change.addSql("ALTER SEQUENCE %s OWNED BY %s", qualifiedName(schema, c.get("sequence_name")), c.ownedBy(schema))
 	}
+	return change
+}
+
+// Drop returns nothing because the ownership of a sequence goes with the sequence
+func (c *SequenceOwnerSchema) Drop() *SchemaChange {
+	return nil
+}
+
+// Change makes the sequence owned by the same column as in db1
+func (c *SequenceOwnerSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*SequenceOwnerSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, SequenceOwnerSchema.Change(obj) needs a SequenceOwnerSchema instance", c2)
+	}
+	schema := c2.get("schema_name")
+	change := newChange("SEQUENCE_OWNER", ActionChange, schema, c.get("sequence_name"))
+	if ownedBy := c.ownedBy(schema); ownedBy != c2.ownedBy(schema) {
+		change.addSql("ALTER SEQUENCE %s OWNED BY %s", qualifiedName(schema, c2.get("sequence_name")), ownedBy)
+	}
+	return change
+}
+
+// compareSequences returns the changes needed to make the sequences match between DBs or schemas
+func compareSequences(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := SequenceRows(cat1.Query("SEQUENCE", sequenceSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := SequenceRows(cat2.Query("SEQUENCE", sequenceSqlTemplate))
 	sort.Sort(rows2)
 
+	if syncSequenceValues {
+		addSequenceValues(cat1, rows1)
+		addSequenceValues(cat2, rows2)
+	}
+
 	// We have to explicitly type this as Schema here for some unknown (to me) reason
 	var schema1 Schema = &SequenceSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &SequenceSchema{rows: rows2, rowNum: -1}
 
 	// Compare the sequences
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
+}
+
+// addSequenceValues copies the current value of each sequence into its row as last_value
+func addSequenceValues(cat Catalog, rows SequenceRows) {
+	values := make(map[string]string)
+	for _, row := range cat.Query("SEQUENCE_VALUE", sequenceValueSqlTemplate) {
+		values[row["compare_name"]] = row["last_value"]
+	}
+	for i, row := range rows {
+		// Copy the row, which may belong to a snapshot
+		withValue := map[string]string{"last_value": "null"}
+		for key, value := range row {
+			withValue[key] = value
+		}
+		if value, ok := values[row["compare_name"]]; ok {
+			withValue["last_value"] = value
+		}
+		rows[i] = withValue
+	}
+}
+
+// compareSequenceOwners returns the changes needed to make the sequences owned by the
+// same columns.  ALL runs it after the tables and columns are created.
+func compareSequenceOwners(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := SequenceRows(cat1.Query("SEQUENCE", sequenceSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := SequenceRows(cat2.Query("SEQUENCE", sequenceSqlTemplate))
+	sort.Sort(rows2)
+
+	var schema1 Schema = &SequenceOwnerSchema{SequenceSchema{rows: rows1, rowNum: -1}}
+	var schema2 Schema = &SequenceOwnerSchema{SequenceSchema{rows: rows2, rowNum: -1}}
+
+	// Compare the owning columns
+	return doDiff(schema1, schema2)
 }
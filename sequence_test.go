@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_SequenceSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	sequence := func() map[string]string {
+		return map[string]string{"schema_name": "s1", "compare_name": "s1.order_id_seq", "sequence_name": "order_id_seq",
+			"data_type": "bigint", "start_value": "1", "minimum_value": "1", "maximum_value": "9223372036854775807",
+			"increment": "1", "cache_size": "1", "cycle": "false",
+			"owned_by_schema": "s1", "owned_by_table": "order", "owned_by_column": "id"}
+	}
+	row1, row2 := sequence(), sequence()
+
+	c1 := &SequenceSchema{rows: SequenceRows{row1}, rowNum: 0}
+	c2 := &SequenceSchema{rows: SequenceRows{row2}, rowNum: 0}
+	expectStatements(t, c1.Add(),
+		"CREATE SEQUENCE s1.order_id_seq AS bigint INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START WITH 1 CACHE 1 NO CYCLE")
+	if change := c1.Change(c2); !change.isEmpty() {
+		t.Errorf("Expected no changes: %q", change.Statements)
+	}
+
+	row2["data_type"], row2["maximum_value"], row2["increment"], row2["cache_size"], row2["cycle"] = "integer", "2147483647", "10", "20", "true"
+	expectStatements(t, c1.Change(c2),
+		"ALTER SEQUENCE s1.order_id_seq AS bigint INCREMENT BY 1 MAXVALUE 9223372036854775807 CACHE 1 NO CYCLE")
+}
+
+func Test_compareSequenceOwners(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	sequence := func(name string, table string, column string) map[string]string {
+		return map[string]string{"schema_name": "s1", "compare_name": "s1." + name, "sequence_name": name,
+			"owned_by_schema": "s1", "owned_by_table": table, "owned_by_column": column}
+	}
+	rows1 := []map[string]string{
+		sequence("a_seq", "order", "id"),
+		sequence("b_seq", "null", "null"),
+		sequence("c_seq", "order", "number"),
+		sequence("d_seq", "User", "id"),
+	}
+	rows2 := []map[string]string{
+		sequence("a_seq", "order", "id"),
+		sequence("b_seq", "order", "old_id"),
+		sequence("c_seq", "null", "null"),
+	}
+	changes := compareSequenceOwners(testCatalog{"SEQUENCE": rows1}, testCatalog{"SEQUENCE": rows2})
+	if len(changes) != 3 {
+		t.Fatalf("Expected changes for b_seq, c_seq, and d_seq: %v", changes)
+	}
+	expectStatements(t, changes[0], "ALTER SEQUENCE s1.b_seq OWNED BY NONE")
+	if changes[0].Kind != "SEQUENCE_OWNER" {
+		t.Errorf("Wrong kind: %s", changes[0].Kind)
+	}
+	expectStatements(t, changes[1], `ALTER SEQUENCE s1.c_seq OWNED BY s1."order".number`)
+	expectStatements(t, changes[2], `ALTER SEQUENCE s1.d_seq OWNED BY s1."User".id`)
+
+	// The change is made with the owning table, which must exist first
+	if changes[2].key != "table:s1.User" || changes[0].key != "sequence:s1.b_seq" {
+		t.Errorf("Wrong keys: %s %s", changes[2].key, changes[0].key)
+	}
+}
+
+func Test_syncSequenceValues(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	syncSequenceValues = true
+	defer func() { syncSequenceValues = false }()
+	sequence := func(increment string, lastValue string) *SequenceSchema {
+		row := map[string]string{"schema_name": "s1", "sequence_name": "order_id_seq", "data_type": "bigint", "start_value": "1",
+			"minimum_value": "1", "maximum_value": "9223372036854775807", "increment": increment, "cache_size": "1",
+			"cycle": "false", "last_value": lastValue}
+		return &SequenceSchema{rows: SequenceRows{row}, rowNum: 0}
+	}
+
+	expectStatements(t, sequence("1", "1500").Change(sequence("1", "null")), "SELECT pg_catalog.setval('s1.order_id_seq', 1500, true)")
+	expectStatements(t, sequence("1", "1500").Change(sequence("1", "20")), "SELECT pg_catalog.setval('s1.order_id_seq', 1500, true)")
+
+	// The sequence never moves backwards
+	expectStatements(t, sequence("1", "1500").Change(sequence("1", "1500")))
+	expectStatements(t, sequence("1", "1500").Change(sequence("1", "2000")))
+	expectStatements(t, sequence("-1", "-1500").Change(sequence("-1", "-2000")))
+	expectStatements(t, sequence("-1", "-2000").Change(sequence("-1", "-1500")), "SELECT pg_catalog.setval('s1.order_id_seq', -2000, true)")
+
+	// Nothing to do when the db1 sequence has never been used
+	expectStatements(t, sequence("1", "null").Change(sequence("1", "null")))
+
+	// The values are only read for --sync-sequence-values
+	rows := SequenceRows{{"compare_name": "s1.a_seq"}, {"compare_name": "s1.b_seq"}}
+	addSequenceValues(testCatalog{"SEQUENCE_VALUE": {{"compare_name": "s1.a_seq", "last_value": "7"}}}, rows)
+	if rows[0]["last_value"] != "7" || rows[1]["last_value"] != "null" {
+		t.Errorf("Wrong values: %v", rows)
+	}
+
+	expectStatements(t, sequence("1", "42").Add(),
+		"CREATE SEQUENCE s1.order_id_seq AS bigint INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START WITH 1 CACHE 1 NO CYCLE",
+		"SELECT pg_catalog.setval('s1.order_id_seq', 42, true)")
+}
@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+// snapshot.go saves the rows of every catalog query to a JSON file so that a
+// database can later be compared without connecting to it
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/joncrlsn/pgutil"
+)
+
+// snapshotVersion is incremented whenever the catalog queries change in a way that
+// makes older snapshots incompatible
+const snapshotVersion = 25
+
+// Snapshot is the catalog state of one database as stored in a snapshot file
+type Snapshot struct {
+	Version       int                            `json:"version"`
+	PgdiffVersion string                         `json:"pgdiffVersion"`
+	Created       string                         `json:"created"`
+	DbName        string                         `json:"dbName"`
+	DbSchema      string                         `json:"dbSchema"`
+	Schemas       []string                       `json:"schemas,omitempty"`
+	Rows          map[string][]map[string]string `json:"rows"`
+}
+
+// takeSnapshot runs every catalog query against the database
+func takeSnapshot(cat *dbCatalog) *Snapshot {
+	snapshot := &Snapshot{
+		Version:       snapshotVersion,
+		PgdiffVersion: version,
+		Created:       time.Now().UTC().Format(time.RFC3339),
+		DbName:        cat.dbInfo.DbName,
+		DbSchema:      cat.dbInfo.DbSchema,
+		Schemas:       cat.schemas,
+		Rows:          make(map[string][]map[string]string),
+	}
+	for name, tpl := range catalogQueries {
+		rows := cat.Query(name, tpl)
+		sortSnapshotRows(rows)
+		snapshot.Rows[name] = rows
+	}
+	return snapshot
+}
+
+// saveSnapshot writes a snapshot of the database to the named file, or to stdout
+// when no file is named
+func saveSnapshot(cat *dbCatalog, fileName string) {
+	var w io.Writer = os.Stdout
+	if len(fileName) > 0 {
+		file, err := os.Create(fileName)
+		check("creating snapshot file", err)
+		defer file.Close()
+		w = file
+	}
+	check("writing snapshot", writeSnapshot(w, takeSnapshot(cat)))
+}
+
+// sortSnapshotRows puts the rows in a stable order so that snapshots of an unchanged
+// database are identical and snapshots kept in version control diff cleanly
+func sortSnapshotRows(rows []map[string]string) {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		// json.Marshal writes map keys in sorted order
+		b, _ := json.Marshal(row)
+		keys[i] = string(b)
+	}
+	sort.Sort(snapshotRows{rows: rows, keys: keys})
+}
+
+// snapshotRows sorts rows by their JSON encoding
+type snapshotRows struct {
+	rows []map[string]string
+	keys []string
+}
+
+func (s snapshotRows) Len() int {
+	return len(s.rows)
+}
+
+func (s snapshotRows) Less(i, j int) bool {
+	return s.keys[i] < s.keys[j]
+}
+
+func (s snapshotRows) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// writeSnapshot writes the snapshot as indented JSON
+func writeSnapshot(w io.Writer, snapshot *Snapshot) error {
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// readSnapshot loads a snapshot file written by writeSnapshot
+func readSnapshot(fileName string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, fmt.Errorf("%s is not a pgdiff snapshot: %v", fileName, err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("%s is a version %d snapshot but this pgdiff reads version %d.  Take a new snapshot.", fileName, snapshot.Version, snapshotVersion)
+	}
+	return snapshot, nil
+}
+
+// ==================================
+// snapshotCatalog definition
+// (implements Catalog)
+// ==================================
+
+// snapshotCatalog reads catalog rows from a snapshot file
+type snapshotCatalog struct {
+	fileName string
+	snapshot *Snapshot
+}
+
+// newSnapshotCatalog reads the snapshot file and makes dbInfo describe the database
+// the snapshot was taken from, so the templates and the schema remapping behave as
+// they would against that database
+func newSnapshotCatalog(fileName string, dbInfo *pgutil.DbInfo) *snapshotCatalog {
+	snapshot, err := readSnapshot(fileName)
+	check("reading snapshot", err)
+	dbInfo.DbName = snapshot.DbName
+	dbInfo.DbSchema = snapshot.DbSchema
+	return &snapshotCatalog{fileName: fileName, snapshot: snapshot}
+}
+
+func (c *snapshotCatalog) Query(name string, tpl *template.Template) []map[string]string {
+	rows, ok := c.snapshot.Rows[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Snapshot %s has no %s rows.  Take a new snapshot.\n", c.fileName, name)
+		os.Exit(1)
+	}
+	// Return a copy since the caller sorts the rows in place
+	return append([]map[string]string(nil), rows...)
+}
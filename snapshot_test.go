@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_snapshotRoundTrip(t *testing.T) {
+	snapshot := &Snapshot{
+		Version:  snapshotVersion,
+		DbName:   "prod",
+		DbSchema: "public",
+		Rows: map[string][]map[string]string{
+			"TABLE": {
+				{"compare_name": "t2", "table_name": "t2"},
+				{"compare_name": "t1", "table_name": "t1"},
+			},
+		},
+	}
+	sortSnapshotRows(snapshot.Rows["TABLE"])
+	if snapshot.Rows["TABLE"][0]["compare_name"] != "t1" {
+		t.Errorf("Rows were not sorted: %v", snapshot.Rows["TABLE"])
+	}
+
+	file, err := ioutil.TempFile("", "pgdiff-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if err := writeSnapshot(file, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	dbInfo := dbInfo1
+	cat := newSnapshotCatalog(file.Name(), &dbInfo)
+	if dbInfo.DbSchema != "public" || dbInfo.DbName != "prod" {
+		t.Errorf("DbInfo was not taken from the snapshot: %v", dbInfo)
+	}
+	rows := cat.Query("TABLE", tableSqlTemplate)
+	if len(rows) != 2 || rows[1]["table_name"] != "t2" {
+		t.Errorf("Wrong rows read from the snapshot: %v", rows)
+	}
+}
+
+func Test_readSnapshotVersion(t *testing.T) {
+	file, err := ioutil.TempFile("", "pgdiff-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	writeSnapshot(file, &Snapshot{Version: snapshotVersion + 1})
+	file.Close()
+
+	if _, err := readSnapshot(file.Name()); err == nil {
+		t.Error("A snapshot with a newer version was read without an error")
+	}
+
+}
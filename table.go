@@ -7,12 +7,11 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
+	"strings"
 	"text/template"
 )
 
@@ -31,14 +30,66 @@ SELECT table_schema
 	  WHEN 'BASE TABLE' THEN 'TABLE' 
 	  ELSE table_type END AS table_type
     , is_insertable_into
+    , c.relpersistence AS persistence
+    , ts.spcname AS tablespace
+    , c.reloptions AS storage_params
+    -- Each column as it is written in CREATE TABLE
+    , (SELECT array_agg(quote_ident(a.attname) || ' ' || pg_catalog.format_type(a.atttypid, a.atttypmod)
+            || CASE WHEN a.attcollation <> ty.typcollation
+               THEN ' COLLATE ' || quote_ident(cn.nspname) || '.' || quote_ident(co.collname) ELSE '' END
+            -- attgenerated is new in PostgreSQL 12, so it is read from the row as JSON
+            || CASE WHEN to_jsonb(a) ->> 'attgenerated' = 's'
+               THEN ' GENERATED ALWAYS AS (' || pg_catalog.pg_get_expr(d.adbin, d.adrelid) || ') STORED'
+               ELSE COALESCE(' DEFAULT ' || pg_catalog.pg_get_expr(d.adbin, d.adrelid), '') END
+            || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+            || CASE a.attidentity WHEN 'a' THEN ' GENERATED ALWAYS AS IDENTITY'
+               WHEN 'd' THEN ' GENERATED BY DEFAULT AS IDENTITY' ELSE '' END
+            -- The options of the sequence behind an identity column
+            || COALESCE((SELECT ' (START WITH ' || s.seqstart || ' INCREMENT BY ' || s.seqincrement
+                   || ' MINVALUE ' || s.seqmin || ' MAXVALUE ' || s.seqmax || ' CACHE ' || s.seqcache
+                   || CASE WHEN s.seqcycle THEN ' CYCLE)' ELSE ' NO CYCLE)' END
+               FROM pg_catalog.pg_depend AS sd
+               INNER JOIN pg_catalog.pg_sequence AS s ON (s.seqrelid = sd.objid)
+               WHERE sd.classid = 'pg_class'::regclass
+               AND sd.refclassid = 'pg_class'::regclass
+               AND sd.refobjid = a.attrelid
+               AND sd.refobjsubid = a.attnum
+               AND sd.deptype = 'i'), '')
+            ORDER BY a.attnum)
+       FROM pg_catalog.pg_attribute AS a
+       INNER JOIN pg_catalog.pg_type AS ty ON (ty.oid = a.atttypid)
+       LEFT OUTER JOIN pg_catalog.pg_collation AS co ON (co.oid = a.attcollation)
+       LEFT OUTER JOIN pg_catalog.pg_namespace AS cn ON (cn.oid = co.collnamespace)
+       LEFT OUTER JOIN pg_catalog.pg_attrdef AS d ON (d.adrelid = a.attrelid AND d.adnum = a.attnum)
+       WHERE a.attrelid = c.oid
+       AND a.attnum > 0
+       AND NOT a.attisdropped) AS column_defs
+    -- The primary key and the (validated) check constraints
+    , (SELECT array_agg('CONSTRAINT ' || quote_ident(con.conname) || ' ' || pg_catalog.pg_get_constraintdef(con.oid, true)
+            ORDER BY con.contype = 'p' DESC, con.conname)
+       FROM pg_catalog.pg_constraint AS con
+       WHERE con.conrelid = c.oid
+       AND con.contype IN ('p', 'c')
+       AND con.conislocal
+       AND con.convalidated) AS constraint_defs
+    -- Partitioning
+    , pg_catalog.pg_get_partkeydef(c.oid) AS partition_key
+    , pn.nspname AS parent_schema
+    , pc.relname AS parent_name
+    , pg_catalog.pg_get_expr(c.relpartbound, c.oid) AS partition_bound
+    -- Row level security (see policy.go)
+    , c.relrowsecurity AS row_security
+    , c.relforcerowsecurity AS force_row_security
 FROM information_schema.tables 
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.nspname = table_schema)
+INNER JOIN pg_catalog.pg_class AS c ON (c.relnamespace = n.oid AND c.relname = table_name)
+LEFT OUTER JOIN pg_catalog.pg_tablespace AS ts ON (ts.oid = c.reltablespace)
+LEFT OUTER JOIN pg_catalog.pg_inherits AS i ON (i.inhrelid = c.oid AND c.relispartition)
+LEFT OUTER JOIN pg_catalog.pg_class AS pc ON (pc.oid = i.inhparent)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS pn ON (pn.oid = pc.relnamespace)
 WHERE table_type = 'BASE TABLE'
-{{if eq $.DbSchema "*" }}
-AND table_schema NOT LIKE 'pg_%' 
-AND table_schema <> 'information_schema' 
-{{else}}
-AND table_schema = '{{$.DbSchema}}'
-{{end}}
+AND {{$.SchemaFilter "table_schema"}}
+AND {{$.ExtensionFilter "pg_class" "c.oid"}}
 ORDER BY compare_name;
 `
 	t := template.New("TableSqlTmpl")
@@ -96,7 +147,7 @@ func (c *TableSchema) NextRow() bool {
 func (c *TableSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*TableSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a TableSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a TableSchema instance", c2)
 		return +999
 	}
 
@@ -105,58 +156,172 @@ func (c *TableSchema) Compare(obj interface{}) int {
 	return val
 }
 
-// Add returns SQL to add the table or view
-func (c TableSchema) Add() {
+// ObjectKey identifies the table in the current row when ordering by dependency
+func (c *TableSchema) ObjectKey() string {
+	return "table:" + c.get("table_schema") + "." + c.get("table_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *TableSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// Add returns SQL to create the table with its columns, primary key, and check
+// constraints
+func (c TableSchema) Add() *SchemaChange {
 	schema := dbInfo2.DbSchema
 	if schema == "*" {
 		schema = c.get("table_schema")
 	}
-	fmt.Printf("CREATE %s %s.%s();", c.get("table_type"), schema, c.get("table_name"))
-	fmt.Println()
+	change := newChange("TABLE", ActionAdd, schema, c.get("table_name"))
+
+	persistence := ""
+	if c.get("persistence") == "u" {
+		persistence = "UNLOGGED "
+	}
+
+	stmt := fmt.Sprintf("CREATE %s%s %s", persistence, c.get("table_type"), qualifiedName(schema, c.get("table_name")))
+	if c.isPartition() {
+		// A partition gets its columns from the partitioned table
+		stmt += " PARTITION OF " + c.parentName(schema)
+		if defs := parseArray(c.get("constraint_defs")); len(defs) > 0 {
+			for i := range defs {
+				defs[i] = remapSchema(defs[i], c.get("table_schema"), schema)
+			}
+			stmt += " (\n    " + strings.Join(defs, ",\n    ") + "\n)"
+		}
+		stmt += " " + c.get("partition_bound")
+	} else {
+		// The columns, then the primary key and check constraints
+		defs := append(parseArray(c.get("column_defs")), parseArray(c.get("constraint_defs"))...)
+		for i := range defs {
+			defs[i] = remapSchema(defs[i], c.get("table_schema"), schema)
+		}
+		if len(defs) > 0 {
+			stmt += " (\n    " + strings.Join(defs, ",\n    ") + "\n)"
+		} else {
+			stmt += " ()"
+		}
+	}
+	if key := c.get("partition_key"); key != "null" && len(key) > 0 {
+		stmt += " PARTITION BY " + key
+	}
+	if params := parseArray(c.get("storage_params")); len(params) > 0 {
+		stmt += fmt.Sprintf(" WITH (%s)", strings.Join(params, ", "))
+	}
+	if tablespace := c.get("tablespace"); tablespace != "null" && len(tablespace) > 0 {
+		stmt += " TABLESPACE " + quoteIdent(tablespace)
+	}
+	change.addSql("%s", stmt)
+	return change
 }
 
 // Drop returns SQL to drop the table or view
-func (c TableSchema) Drop() {
-	fmt.Printf("DROP %s %s.%s;\n", c.get("table_type"), c.get("table_schema"), c.get("table_name"))
+func (c TableSchema) Drop() *SchemaChange {
+	change := newChange("TABLE", ActionDrop, c.get("table_schema"), c.get("table_name"))
+	change.Destructive = true
+	change.addSql("DROP %s %s", c.get("table_type"), qualifiedName(c.get("table_schema"), c.get("table_name")))
+	return change
 }
 
-// Change handles the case where the table and column match, but the details do not
-func (c TableSchema) Change(obj interface{}) {
+// Change attaches or detaches the table when it is a partition in only one database,
+// or a partition of a different table or with different bounds
+func (c TableSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*TableSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a TableSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a TableSchema instance", c2)
 	}
-	// There's nothing we need to do here
-}
+	schema := c2.get("table_schema")
+	change := newChange("TABLE", ActionChange, schema, c.get("table_name"))
+	name := qualifiedName(schema, c2.get("table_name"))
 
-// compareTables outputs SQL to make the table names match between DBs
-func compareTables(conn1 *sql.DB, conn2 *sql.DB) {
+	if c.get("partition_key") != c2.get("partition_key") {
+		change.addWarning("WARNING: Table %s is partitioned by %s in db1 and by %s in db2.", name, c.get("partition_key"), c2.get("partition_key"))
+		change.addWarning("The partitioning of a table can't be changed.  It must be recreated by hand.")
+	}
 
-	buf1 := new(bytes.Buffer)
-	tableSqlTemplate.Execute(buf1, dbInfo1)
+	parent1, parent2 := c.parentName(schema), c2.parentName(schema)
+	if parent1 != parent2 || c.get("partition_bound") != c2.get("partition_bound") {
+		if c2.isPartition() {
+			change.addSql("ALTER TABLE %s DETACH PARTITION %s", parent2, name)
+		}
+		if c.isPartition() {
+			change.addSql("ALTER TABLE %s ATTACH PARTITION %s %s", parent1, name, c.get("partition_bound"))
+		}
+	}
+	return change
+}
 
-	buf2 := new(bytes.Buffer)
-	tableSqlTemplate.Execute(buf2, dbInfo2)
+// isPartition tells you whether the table is a partition of a partitioned table
+func (c *TableSchema) isPartition() bool {
+	return c.get("parent_name") != "null" && len(c.get("parent_name")) > 0
+}
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
+// parentName returns the qualified name of the partitioned table that the table is a
+// partition of, or "" if it isn't a partition.  A parent in the table's own schema is
+// assumed to be in the given schema.
+func (c *TableSchema) parentName(schema string) string {
+	if !c.isPartition() {
+		return ""
+	}
+	if c.get("parent_schema") != c.get("table_schema") {
+		schema = c.get("parent_schema")
+	}
+	return qualifiedName(schema, c.get("parent_name"))
+}
 
-	rows1 := make(TableRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
+// isPartitionChange tells you whether a table change is for a partition
+func isPartitionChange(change *SchemaChange) bool {
+	row := change.DB1
+	if change.Action == ActionDrop {
+		row = change.DB2
 	}
+	return row["parent_name"] != "null" && len(row["parent_name"]) > 0
+}
+
+// compareTables returns the changes needed to make the table names match between DBs
+func compareTables(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := TableRows(cat1.Query("TABLE", tableSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(TableRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := TableRows(cat2.Query("TABLE", tableSqlTemplate))
 	sort.Sort(rows2)
 
 	// We have to explicitly type this as Schema here
 	var schema1 Schema = &TableSchema{rows: rows1, rowNum: -1}
 	var schema2 Schema = &TableSchema{rows: rows2, rowNum: -1}
 
-	// Compare the tables
-	doDiff(schema1, schema2)
+	// Compare the tables.  Partitions are dropped before the other tables and created
+	// after them, so their partitioned tables exist.
+	changes := doDiff(schema1, schema2)
+	rank := func(change *SchemaChange) int {
+		switch {
+		case !isPartitionChange(change):
+			return 1
+		case change.Action == ActionDrop:
+			return 0
+		}
+		return 2
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return rank(changes[i]) < rank(changes[j])
+	})
+	return changes
+}
+
+// addedTables returns the names (schema.table in db1) of the tables that are missing
+// from db2.  Their columns and primary keys are part of the CREATE TABLE statement,
+// so the COLUMN and INDEX diffs leave them out.
+func addedTables(cat1 Catalog, cat2 Catalog) map[string]bool {
+	added := make(map[string]bool)
+	for _, change := range compareTables(cat1, cat2) {
+		if change.Action == ActionAdd {
+			added[change.DB1["table_schema"]+"."+change.DB1["table_name"]] = true
+		}
+	}
+	return added
 }
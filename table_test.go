@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+)
+
+// testCatalog returns canned rows for each catalog query
+type testCatalog map[string][]map[string]string
+
+func (c testCatalog) Query(name string, tpl *template.Template) []map[string]string {
+	rows := make([]map[string]string, len(c[name]))
+	copy(rows, c[name])
+	return rows
+}
+
+func Test_TableSchemaAdd(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	table := &TableSchema{rows: TableRows{
+		{"table_schema": "s1", "table_name": "t1", "table_type": "TABLE", "persistence": "u",
+			"column_defs":     `{"id integer NOT NULL GENERATED ALWAYS AS IDENTITY","name character varying(40) DEFAULT 'x'::character varying"}`,
+			"constraint_defs": `{"CONSTRAINT t1_pkey PRIMARY KEY (id)","CONSTRAINT t1_name_check CHECK (name <> ''::text)"}`,
+			"storage_params":  "{fillfactor=70}", "tablespace": "fast"},
+	}, rowNum: 0}
+	expectStatements(t, table.Add(), `CREATE UNLOGGED TABLE s1.t1 (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
+    name character varying(40) DEFAULT 'x'::character varying,
+    CONSTRAINT t1_pkey PRIMARY KEY (id),
+    CONSTRAINT t1_name_check CHECK (name <> ''::text)
+) WITH (fillfactor=70) TABLESPACE fast`)
+
+	table = &TableSchema{rows: TableRows{
+		{"table_schema": "s1", "table_name": "t2", "table_type": "TABLE", "persistence": "p",
+			"column_defs": "null", "constraint_defs": "null", "storage_params": "null", "tablespace": "null"},
+	}, rowNum: 0}
+	expectStatements(t, table.Add(), `CREATE TABLE s1.t2 ()`)
+
+	// Defaults and types that refer to the first schema refer to the second one instead
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "s1", "s2"
+	defer func() { dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*" }()
+	table = &TableSchema{rows: TableRows{
+		{"table_schema": "s1", "table_name": "t3", "table_type": "TABLE", "persistence": "p",
+			"column_defs":     `{"id integer DEFAULT nextval('s1.t3_id_seq'::regclass) NOT NULL","status s1.status","total numeric GENERATED ALWAYS AS ((price * qty)) STORED"}`,
+			"constraint_defs": "null", "storage_params": "null", "tablespace": "null"},
+	}, rowNum: 0}
+	expectStatements(t, table.Add(), `CREATE TABLE s2.t3 (
+    id integer DEFAULT nextval('s2.t3_id_seq'::regclass) NOT NULL,
+    status s2.status,
+    total numeric GENERATED ALWAYS AS ((price * qty)) STORED
+)`)
+}
+
+func Test_remapSchema(t *testing.T) {
+	tests := [][4]string{
+		{"nextval('s1.id_seq'::regclass)", "s1", "s2", "nextval('s2.id_seq'::regclass)"},
+		{"s1.f(xs1.a, s1.b)", "s1", "Sales", `"Sales".f(xs1.a, "Sales".b)`},
+		{`"My Schema".t`, "My Schema", "s2", "s2.t"},
+		{"s1.t", "s1", "s1", "s1.t"},
+	}
+	for _, test := range tests {
+		if def := remapSchema(test[0], test[1], test[2]); def != test[3] {
+			t.Errorf("remapSchema(%q) is %s instead of %s", test[0], def, test[3])
+		}
+	}
+}
+
+// The columns and primary key of a new table are part of its CREATE TABLE statement
+func Test_addedTablesSkipped(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	cat1 := testCatalog{
+		"TABLE": {
+			{"table_schema": "s1", "compare_name": "s1.t1", "table_name": "t1", "table_type": "TABLE"},
+			{"table_schema": "s1", "compare_name": "s1.t2", "table_name": "t2", "table_type": "TABLE"},
+		},
+		"COLUMN": {
+			{"table_schema": "s1", "compare_name": "s1.t1.00001id", "table_name": "t1", "column_name": "id",
+				"data_type": "integer", "is_nullable": "NO", "column_default": "null", "is_identity": "NO"},
+			{"table_schema": "s1", "compare_name": "s1.t2.00001id", "table_name": "t2", "column_name": "id",
+				"data_type": "integer", "is_nullable": "NO", "column_default": "null", "is_identity": "NO"},
+		},
+		"INDEX": {
+			{"compare_name": "s1.t1.t1_pkey", "schema_name": "s1", "table_name": "t1", "index_name": "t1_pkey", "pk": "true", "uq": "true",
+				"index_def": "CREATE UNIQUE INDEX t1_pkey ON s1.t1 USING btree (id)", "constraint_def": "PRIMARY KEY (id)"},
+			{"compare_name": "s1.t2.t2_pkey", "schema_name": "s1", "table_name": "t2", "index_name": "t2_pkey", "pk": "true", "uq": "true",
+				"index_def": "CREATE UNIQUE INDEX t2_pkey ON s1.t2 USING btree (id)", "constraint_def": "PRIMARY KEY (id)"},
+		},
+	}
+	cat2 := testCatalog{"TABLE": cat1["TABLE"][:1]}
+
+	changes := compareColumns(cat1, cat2)
+	if len(changes) != 1 || changes[0].Name != "s1.t1.id" {
+		t.Errorf("Expected only the column of the existing table: %v", changes)
+	}
+	changes = compareIndexes(cat1, cat2)
+	if len(changes) != 1 || changes[0].Name != "s1.t1_pkey" {
+		t.Errorf("Expected only the primary key of the existing table: %v", changes)
+	}
+}
+
+func Test_TableSchemaPartitions(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	parent := map[string]string{"table_schema": "s1", "compare_name": "s1.events", "table_name": "events", "table_type": "TABLE",
+		"persistence": "p", "column_defs": `{"id bigint NOT NULL","created_at timestamp with time zone NOT NULL"}`,
+		"constraint_defs": `{"CONSTRAINT events_pkey PRIMARY KEY (id, created_at)"}`, "storage_params": "null", "tablespace": "null",
+		"partition_key": "RANGE (created_at)", "parent_schema": "null", "parent_name": "null", "partition_bound": "null"}
+	partition := map[string]string{"table_schema": "s1", "compare_name": "s1.events_2024_01", "table_name": "events_2024_01", "table_type": "TABLE",
+		"persistence": "p", "column_defs": `{"id bigint NOT NULL","created_at timestamp with time zone NOT NULL"}`,
+		"constraint_defs": "null", "storage_params": "null", "tablespace": "null", "partition_key": "null",
+		"parent_schema": "s1", "parent_name": "events",
+		"partition_bound": "FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')"}
+
+	table := &TableSchema{rows: TableRows{parent}, rowNum: 0}
+	expectStatements(t, table.Add(), `CREATE TABLE s1.events (
+    id bigint NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    CONSTRAINT events_pkey PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at)`)
+
+	table = &TableSchema{rows: TableRows{partition}, rowNum: 0}
+	expectStatements(t, table.Add(),
+		`CREATE TABLE s1.events_2024_01 PARTITION OF s1.events FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')`)
+
+	// A table that is only a partition in db1 is attached, and one with other bounds
+	// is detached and attached again
+	detached := map[string]string{"table_schema": "s1", "table_name": "events_2024_01", "partition_key": "null",
+		"parent_schema": "null", "parent_name": "null", "partition_bound": "null"}
+	table2 := &TableSchema{rows: TableRows{detached}, rowNum: 0}
+	expectStatements(t, table.Change(table2),
+		`ALTER TABLE s1.events ATTACH PARTITION s1.events_2024_01 FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')`)
+	other := map[string]string{"table_schema": "s1", "table_name": "events_2024_01", "partition_key": "null",
+		"parent_schema": "s1", "parent_name": "events", "partition_bound": "FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-01-15 00:00:00+00')"}
+	table2 = &TableSchema{rows: TableRows{other}, rowNum: 0}
+	expectStatements(t, table.Change(table2),
+		`ALTER TABLE s1.events DETACH PARTITION s1.events_2024_01`,
+		`ALTER TABLE s1.events ATTACH PARTITION s1.events_2024_01 FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')`)
+
+	// Partitions are created after their partitioned table, whatever their names
+	partition["compare_name"], partition["table_name"] = "s1.a_events_2024_01", "a_events_2024_01"
+	changes := compareTables(testCatalog{"TABLE": {parent, partition}}, testCatalog{})
+	if len(changes) != 2 || changes[0].Name != "s1.events" {
+		t.Errorf("The partitioned table should be created first: %v", changes)
+	}
+}
+
+// The indexes of new partitions come from the partitioned table's indexes
+func Test_partitionIndexes(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	parentIndex := map[string]string{"compare_name": "s1.events.events_created_idx", "schema_name": "s1", "table_name": "events",
+		"index_name": "events_created_idx", "pk": "false", "uq": "false", "partitioned": "true", "constraint_def": "null",
+		"index_def": "CREATE INDEX events_created_idx ON ONLY s1.events USING btree (created_at)", "parent_index": "null"}
+	childIndex := map[string]string{"compare_name": "s1.events_2024_01.events_2024_01_created_at_idx", "schema_name": "s1",
+		"table_name": "events_2024_01", "index_name": "events_2024_01_created_at_idx", "pk": "false", "uq": "false", "partitioned": "false",
+		"constraint_def": "null", "index_def": "CREATE INDEX events_2024_01_created_at_idx ON s1.events_2024_01 USING btree (created_at)",
+		"parent_index_schema": "s1", "parent_index": "events_created_idx"}
+	tables := []map[string]string{
+		{"table_schema": "s1", "compare_name": "s1.events", "table_name": "events", "table_type": "TABLE"},
+		{"table_schema": "s1", "compare_name": "s1.events_2024_01", "table_name": "events_2024_01", "table_type": "TABLE"},
+	}
+
+	// The partitioned index is created on the partitions too
+	changes := compareIndexes(testCatalog{"TABLE": tables, "INDEX": {parentIndex, childIndex}}, testCatalog{"TABLE": tables})
+	if len(changes) != 1 {
+		t.Fatalf("Expected only the index of the partitioned table: %v", changes)
+	}
+	expectStatements(t, changes[0], "CREATE INDEX events_created_idx ON s1.events USING btree (created_at)")
+
+	// An index missing from an existing partition is created and attached
+	changes = compareIndexes(testCatalog{"TABLE": tables, "INDEX": {parentIndex, childIndex}}, testCatalog{"TABLE": tables, "INDEX": {parentIndex}})
+	if len(changes) != 1 {
+		t.Fatalf("Expected only the index of the partition: %v", changes)
+	}
+	expectStatements(t, changes[0],
+		"CREATE INDEX events_2024_01_created_at_idx ON s1.events_2024_01 USING btree (created_at)",
+		"ALTER INDEX s1.events_created_idx ATTACH PARTITION s1.events_2024_01_created_at_idx")
+
+	// It is dropped with the index of the partitioned table
+	changes = compareIndexes(testCatalog{"TABLE": tables}, testCatalog{"TABLE": tables, "INDEX": {parentIndex, childIndex}})
+	if len(changes) != 1 || changes[0].Name != "s1.events_created_idx" {
+		t.Errorf("Expected only the index of the partitioned table to be dropped: %v", changes)
+	}
+}
@@ -7,11 +7,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"fmt"
 	"github.com/joncrlsn/misc"
-	"github.com/joncrlsn/pgutil"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -34,12 +32,12 @@ func initTriggerSqlTemplate() *template.Template {
     INNER JOIN pg_catalog.pg_class c ON (c.oid = t.tgrelid)
     INNER JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
 	WHERE not t.tgisinternal
-    {{if eq $.DbSchema "*" }}
-    AND n.nspname NOT LIKE 'pg_%' 
-    AND n.nspname <> 'information_schema' 
-    {{else}}
-    AND n.nspname = '{{$.DbSchema}}'
-    {{end}}
+    -- Leave out the copies of a partitioned table's triggers on its partitions
+    AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend AS d
+        WHERE d.classid = 'pg_trigger'::regclass AND d.objid = t.oid
+        AND d.refclassid = 'pg_trigger'::regclass AND d.deptype = 'P')
+    AND {{$.SchemaFilter "n.nspname"}}
+    AND {{$.ExtensionFilter "pg_class" "c.oid"}}
 	`
 	t := template.New("TriggerSqlTmpl")
 	template.Must(t.Parse(sql))
@@ -96,7 +94,7 @@ func (c *TriggerSchema) NextRow() bool {
 func (c *TriggerSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*TriggerSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a TriggerSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a TriggerSchema instance", c2)
 		return +999
 	}
 
@@ -104,8 +102,21 @@ func (c *TriggerSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the trigger in the current row when ordering by dependency
+func (c *TriggerSchema) ObjectKey() string {
+	return "trigger:" + c.get("schema_name") + "." + c.get("table_name") + "." + c.get("trigger_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *TriggerSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to create the trigger
-func (c TriggerSchema) Add() {
+func (c TriggerSchema) Add() *SchemaChange {
 	// If we are comparing two different schemas against each other, we need to do some
 	// modification of the first trigger definition so we create it in the right schema
 	triggerDef := c.get("trigger_def")
@@ -114,27 +125,48 @@ func (c TriggerSchema) Add() {
 		schemaName = dbInfo2.DbSchema
 		triggerDef = strings.Replace(
 			triggerDef,
-			fmt.Sprintf(" %s.%s ", c.get("schema_name"), c.get("table_name")),
-			fmt.Sprintf(" %s.%s ", schemaName, c.get("table_name")),
+			" "+qualifiedName(c.get("schema_name"), c.get("table_name"))+" ",
+			" "+qualifiedName(schemaName, c.get("table_name"))+" ",
 			-1)
 	}
 
-	fmt.Printf("%s;\n", triggerDef)
+	change := newChange("TRIGGER", ActionAdd, schemaName, c.get("table_name")+"."+c.get("trigger_name"))
+	change.addSql("%s", triggerDef)
+	if c.get("enabled") != "O" {
+		c.addEnable(change, schemaName)
+	}
+	return change
+}
+
+// addEnable adds the SQL to set whether the trigger fires (and for which replication
+// roles) the way it is set in db1.  CREATE TRIGGER creates it enabled (O).
+func (c *TriggerSchema) addEnable(change *SchemaChange, schema string) {
+	enable := map[string]string{"O": "ENABLE", "D": "DISABLE", "R": "ENABLE REPLICA", "A": "ENABLE ALWAYS"}[c.get("enabled")]
+	if len(enable) == 0 {
+		change.addWarning("WARNING: Trigger %s on %s has the unknown tgenabled value %s, so whether it fires is not changed.",
+			quoteIdent(c.get("trigger_name")), qualifiedName(schema, c.get("table_name")), c.get("enabled"))
+		return
+	}
+	change.addSql("ALTER TABLE %s %s TRIGGER %s", qualifiedName(schema, c.get("table_name")), enable, quoteIdent(c.get("trigger_name")))
 }
 
 // Drop returns SQL to drop the trigger
-func (c TriggerSchema) Drop() {
-	fmt.Printf("DROP TRIGGER %s ON %s.%s;\n", c.get("trigger_name"), c.get("schema_name"), c.get("table_name"))
+func (c TriggerSchema) Drop() *SchemaChange {
+	change := newChange("TRIGGER", ActionDrop, c.get("schema_name"), c.get("table_name")+"."+c.get("trigger_name"))
+	change.Destructive = true
+	change.addSql("DROP TRIGGER %s ON %s", quoteIdent(c.get("trigger_name")), qualifiedName(c.get("schema_name"), c.get("table_name")))
+	return change
 }
 
 // Change handles the case where the trigger names match, but the definition does not
-func (c TriggerSchema) Change(obj interface{}) {
+func (c TriggerSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*TriggerSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a TriggerSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a TriggerSchema instance", c2)
 	}
+	change := newChange("TRIGGER", ActionChange, c2.get("schema_name"), c.get("table_name")+"."+c.get("trigger_name"))
 	if c.get("trigger_def") != c2.get("trigger_def") {
-		fmt.Println("-- This function looks different so we'll drop and recreate it:")
+		change.addWarning("This function looks different so we'll drop and recreate it:")
 
 		// If we are comparing two different schemas against each other, we need to do some
 		// modification of the first trigger definition so we create it in the right schema
@@ -144,41 +176,35 @@ func (c TriggerSchema) Change(obj interface{}) {
 			schemaName = dbInfo2.DbSchema
 			triggerDef = strings.Replace(
 				triggerDef,
-				fmt.Sprintf(" %s.%s ", c.get("schema_name"), c.get("table_name")),
-				fmt.Sprintf(" %s.%s ", schemaName, c.get("table_name")),
+				" "+qualifiedName(c.get("schema_name"), c.get("table_name"))+" ",
+				" "+qualifiedName(schemaName, c.get("table_name"))+" ",
 				-1)
 		}
 
 		// The trigger_def column has everything needed to rebuild the function
-		fmt.Printf("DROP TRIGGER %s ON %s.%s;\n", c.get("trigger_name"), schemaName, c.get("table_name"))
-		fmt.Println("-- STATEMENT-BEGIN")
-		fmt.Printf("%s;\n", triggerDef)
-		fmt.Println("-- STATEMENT-END")
+		change.addSql("DROP TRIGGER %s ON %s", quoteIdent(c.get("trigger_name")), qualifiedName(schemaName, c.get("table_name")))
+		change.addSql("%s", triggerDef)
+		if c.get("enabled") != "O" {
+			c.addEnable(change, schemaName)
+		}
+	} else if c.get("enabled") != c2.get("enabled") {
+		// Enabling or disabling the trigger doesn't need it to be recreated
+		schemaName := c2.get("schema_name")
+		if dbInfo1.DbSchema != dbInfo2.DbSchema {
+			schemaName = dbInfo2.DbSchema
+		}
+		c.addEnable(change, schemaName)
 	}
+	return change
 }
 
-// compareTriggers outputs SQL to make the triggers match between DBs
-func compareTriggers(conn1 *sql.DB, conn2 *sql.DB) {
-
-	buf1 := new(bytes.Buffer)
-	triggerSqlTemplate.Execute(buf1, dbInfo1)
+// compareTriggers returns the changes needed to make the triggers match between DBs
+func compareTriggers(cat1 Catalog, cat2 Catalog) []*SchemaChange {
 
-	buf2 := new(bytes.Buffer)
-	triggerSqlTemplate.Execute(buf2, dbInfo2)
-
-	rowChan1, _ := pgutil.QueryStrings(conn1, buf1.String())
-	rowChan2, _ := pgutil.QueryStrings(conn2, buf2.String())
-
-	rows1 := make(TriggerRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
-	}
+	rows1 := TriggerRows(cat1.Query("TRIGGER", triggerSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(TriggerRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := TriggerRows(cat2.Query("TRIGGER", triggerSqlTemplate))
 	sort.Sort(rows2)
 
 	// We must explicitly type this as Schema here
@@ -186,5 +212,5 @@ func compareTriggers(conn1 *sql.DB, conn2 *sql.DB) {
 	var schema2 Schema = &TriggerSchema{rows: rows2, rowNum: -1}
 
 	// Compare the triggers
-	doDiff(schema1, schema2)
+	return doDiff(schema1, schema2)
 }
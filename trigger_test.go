@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_TriggerSchemaEnabled(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	def := "CREATE TRIGGER audit AFTER UPDATE ON s1.account FOR EACH ROW EXECUTE FUNCTION s1.audit()"
+	row1 := map[string]string{"schema_name": "s1", "table_name": "account", "trigger_name": "audit", "trigger_def": def, "enabled": "R"}
+	row2 := map[string]string{"schema_name": "s1", "table_name": "account", "trigger_name": "audit", "trigger_def": def, "enabled": "O"}
+
+	c1 := &TriggerSchema{rows: TriggerRows{row1}, rowNum: 0}
+	c2 := &TriggerSchema{rows: TriggerRows{row2}, rowNum: 0}
+	expectStatements(t, c1.Add(), def, "ALTER TABLE s1.account ENABLE REPLICA TRIGGER audit")
+	expectStatements(t, c2.Add(), def)
+
+	// The trigger is only enabled or disabled, not recreated
+	expectStatements(t, c1.Change(c2), "ALTER TABLE s1.account ENABLE REPLICA TRIGGER audit")
+	row1["enabled"] = "D"
+	expectStatements(t, c1.Change(c2), "ALTER TABLE s1.account DISABLE TRIGGER audit")
+	expectStatements(t, c2.Change(c1), "ALTER TABLE s1.account ENABLE TRIGGER audit")
+	row1["enabled"] = "A"
+	expectStatements(t, c1.Change(c2), "ALTER TABLE s1.account ENABLE ALWAYS TRIGGER audit")
+
+	// A recreated trigger keeps its state
+	row1["trigger_def"] = "CREATE TRIGGER audit AFTER INSERT OR UPDATE ON s1.account FOR EACH ROW EXECUTE FUNCTION s1.audit()"
+	expectStatements(t, c1.Change(c2),
+		"DROP TRIGGER audit ON s1.account",
+		row1["trigger_def"],
+		"ALTER TABLE s1.account ENABLE ALWAYS TRIGGER audit")
+
+	// An unknown state is reported instead of guessed
+	row1["trigger_def"], row1["enabled"] = def, "X"
+	change := c1.Change(c2)
+	expectStatements(t, change)
+	if len(change.Warnings) != 1 {
+		t.Errorf("Expected a warning about the unknown state: %q", change.Warnings)
+	}
+}
@@ -0,0 +1,452 @@
+//
+// Copyright (c) 2017 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/joncrlsn/misc"
+)
+
+var (
+	typeSqlTemplate = initTypeSqlTemplate()
+)
+
+// Initializes the Sql template
+func initTypeSqlTemplate() *template.Template {
+	sql := `
+SELECT {{if eq $.DbSchema "*" }}n.nspname || '.' || {{end}}t.typname AS compare_name
+    , n.nspname AS schema_name
+    , t.typname AS type_name
+    , CASE t.typtype
+      WHEN 'e' THEN 'ENUM'
+      WHEN 'c' THEN 'COMPOSITE'
+      WHEN 'd' THEN 'DOMAIN'
+      WHEN 'r' THEN 'RANGE' END AS type_kind
+    -- enums
+    , (SELECT array_agg(e.enumlabel ORDER BY e.enumsortorder)
+       FROM pg_catalog.pg_enum AS e
+       WHERE e.enumtypid = t.oid) AS labels
+    -- composite types
+    , (SELECT array_agg(a.attname ORDER BY a.attnum)
+       FROM pg_catalog.pg_attribute AS a
+       WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped) AS attribute_names
+    , (SELECT array_agg(pg_catalog.format_type(a.atttypid, a.atttypmod)
+            || CASE WHEN a.attcollation <> at.typcollation
+               THEN ' COLLATE ' || quote_ident(acn.nspname) || '.' || quote_ident(aco.collname) ELSE '' END
+            ORDER BY a.attnum)
+       FROM pg_catalog.pg_attribute AS a
+       INNER JOIN pg_catalog.pg_type AS at ON (at.oid = a.atttypid)
+       LEFT OUTER JOIN pg_catalog.pg_collation AS aco ON (aco.oid = a.attcollation)
+       LEFT OUTER JOIN pg_catalog.pg_namespace AS acn ON (acn.oid = aco.collnamespace)
+       WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped) AS attribute_types
+    -- domains
+    , CASE WHEN t.typtype = 'd' THEN pg_catalog.format_type(t.typbasetype, t.typtypmod) END AS base_type
+    , CASE WHEN t.typcollation <> bt.typcollation
+      THEN quote_ident(cn.nspname) || '.' || quote_ident(co.collname) END AS collation
+    , t.typdefault AS default_value
+    , t.typnotnull AS not_null
+    , (SELECT array_agg(con.conname ORDER BY con.conname)
+       FROM pg_catalog.pg_constraint AS con
+       WHERE con.contypid = t.oid AND con.contype = 'c') AS constraint_names
+    , (SELECT array_agg(pg_catalog.pg_get_constraintdef(con.oid, true) ORDER BY con.conname)
+       FROM pg_catalog.pg_constraint AS con
+       WHERE con.contypid = t.oid AND con.contype = 'c') AS constraint_defs
+    -- ranges
+    , pg_catalog.format_type(r.rngsubtype, NULL) AS subtype
+    , NULLIF(r.rngsubdiff, 0)::regproc AS subtype_diff
+    -- the options that are not the defaults for the subtype
+    , CASE WHEN NOT oc.opcdefault THEN quote_ident(ocn.nspname) || '.' || quote_ident(oc.opcname) END AS subtype_opclass
+    , CASE WHEN r.rngcollation <> st.typcollation
+      THEN quote_ident(rcn.nspname) || '.' || quote_ident(rco.collname) END AS range_collation
+    , NULLIF(r.rngcanonical, 0)::regproc AS canonical
+    , mn.nspname AS multirange_schema
+    , mt.typname AS multirange_name
+FROM pg_catalog.pg_type AS t
+INNER JOIN pg_catalog.pg_namespace AS n ON (n.oid = t.typnamespace)
+LEFT OUTER JOIN pg_catalog.pg_class AS c ON (c.oid = t.typrelid)
+LEFT OUTER JOIN pg_catalog.pg_range AS r ON (r.rngtypid = t.oid)
+LEFT OUTER JOIN pg_catalog.pg_type AS st ON (st.oid = r.rngsubtype)
+LEFT OUTER JOIN pg_catalog.pg_opclass AS oc ON (oc.oid = r.rngsubopc)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS ocn ON (ocn.oid = oc.opcnamespace)
+LEFT OUTER JOIN pg_catalog.pg_collation AS rco ON (rco.oid = r.rngcollation)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS rcn ON (rcn.oid = rco.collnamespace)
+-- Multiranges are new in PostgreSQL 14, so rngmultitypid is read from the row as JSON
+LEFT OUTER JOIN pg_catalog.pg_type AS mt ON (mt.oid = (to_jsonb(r) ->> 'rngmultitypid')::oid)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS mn ON (mn.oid = mt.typnamespace)
+LEFT OUTER JOIN pg_catalog.pg_type AS bt ON (bt.oid = t.typbasetype)
+LEFT OUTER JOIN pg_catalog.pg_collation AS co ON (co.oid = t.typcollation)
+LEFT OUTER JOIN pg_catalog.pg_namespace AS cn ON (cn.oid = co.collnamespace)
+WHERE t.typtype IN ('e', 'c', 'd', 'r')
+-- Leave out the row types of tables, views, etc
+AND (t.typtype <> 'c' OR c.relkind = 'c')
+AND {{$.SchemaFilter "n.nspname"}}
+AND {{$.ExtensionFilter "pg_type" "t.oid"}}
+`
+	t := template.New("TypeSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
+// ==================================
+// TypeRows definition
+// ==================================
+
+// TypeRows is a sortable slice of string maps
+type TypeRows []map[string]string
+
+func (slice TypeRows) Len() int {
+	return len(slice)
+}
+
+func (slice TypeRows) Less(i, j int) bool {
+	return slice[i]["compare_name"] < slice[j]["compare_name"]
+}
+
+func (slice TypeRows) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// ==================================
+// TypeSchema definition
+// (implements Schema -- defined in pgdiff.go)
+// ==================================
+
+// TypeSchema holds a slice of rows from one of the databases as well as
+// a reference to the current row of data we're viewing.
+type TypeSchema struct {
+	rows   TypeRows
+	rowNum int
+	done   bool
+}
+
+// get returns the value from the current row for the given key
+func (c *TypeSchema) get(key string) string {
+	if c.rowNum >= len(c.rows) {
+		return ""
+	}
+	return c.rows[c.rowNum][key]
+}
+
+// NextRow increments the rowNum and tells you whether or not there are more
+func (c *TypeSchema) NextRow() bool {
+	if c.rowNum >= len(c.rows)-1 {
+		c.done = true
+	}
+	c.rowNum = c.rowNum + 1
+	return !c.done
+}
+
+// Compare tells you, in one pass, whether or not the first row matches, is less than, or greater than the second row
+func (c *TypeSchema) Compare(obj interface{}) int {
+	c2, ok := obj.(*TypeSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a TypeSchema instance", c2)
+		return +999
+	}
+
+	val := misc.CompareStrings(c.get("compare_name"), c2.get("compare_name"))
+	return val
+}
+
+// ObjectKey identifies the type in the current row when ordering by dependency
+func (c *TypeSchema) ObjectKey() string {
+	return "type:" + c.get("schema_name") + "." + c.get("type_name")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *TypeSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
+// attributes returns the attributes of a composite type in the given schema as they
+// are written in CREATE TYPE, keyed by attribute name
+func (c *TypeSchema) attributes(schema string) (names []string, defs map[string]string) {
+	names = parseArray(c.get("attribute_names"))
+	types := parseArray(c.get("attribute_types"))
+	defs = make(map[string]string)
+	for i, name := range names {
+		if i < len(types) {
+			defs[name] = remapSchema(types[i], c.get("schema_name"), schema)
+		}
+	}
+	return names, defs
+}
+
+// domainConstraints returns the check constraints of a domain in the given schema
+// keyed by name
+func (c *TypeSchema) domainConstraints(schema string) (names []string, defs map[string]string) {
+	names = parseArray(c.get("constraint_names"))
+	checks := parseArray(c.get("constraint_defs"))
+	defs = make(map[string]string)
+	for i, name := range names {
+		if i < len(checks) {
+			defs[name] = remapSchema(checks[i], c.get("schema_name"), schema)
+		}
+	}
+	return names, defs
+}
+
+// baseType returns the base type of a domain in the given schema
+func (c *TypeSchema) baseType(schema string) string {
+	return remapSchema(c.get("base_type"), c.get("schema_name"), schema)
+}
+
+// createSql returns the statement that creates the type in the given schema
+func (c *TypeSchema) createSql(schema string) string {
+	name := qualifiedName(schema, c.get("type_name"))
+	switch c.get("type_kind") {
+	case "ENUM":
+		labels := parseArray(c.get("labels"))
+		quoted := make([]string, len(labels))
+		for i, label := range labels {
+			quoted[i] = quoteLiteral(label)
+		}
+		return fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", name, strings.Join(quoted, ", "))
+	case "COMPOSITE":
+		names, defs := c.attributes(schema)
+		attributes := make([]string, len(names))
+		for i, attName := range names {
+			attributes[i] = quoteIdent(attName) + " " + defs[attName]
+		}
+		return fmt.Sprintf("CREATE TYPE %s AS (%s)", name, strings.Join(attributes, ", "))
+	case "DOMAIN":
+		stmt := fmt.Sprintf("CREATE DOMAIN %s AS %s", name, c.baseType(schema))
+		if c.get("collation") != "null" && len(c.get("collation")) > 0 {
+			stmt += " COLLATE " + c.get("collation")
+		}
+		if c.get("default_value") != "null" && len(c.get("default_value")) > 0 {
+			stmt += " DEFAULT " + remapSchema(c.get("default_value"), c.get("schema_name"), schema)
+		}
+		if c.get("not_null") == "true" {
+			stmt += " NOT NULL"
+		}
+		names, defs := c.domainConstraints(schema)
+		for _, conName := range names {
+			stmt += fmt.Sprintf(" CONSTRAINT %s %s", quoteIdent(conName), defs[conName])
+		}
+		return stmt
+	case "RANGE":
+		return fmt.Sprintf("CREATE TYPE %s AS RANGE (%s)", name, strings.Join(c.rangeOptions(schema), ", "))
+	}
+	return ""
+}
+
+// rangeOptions returns the options of a range type in the given schema as they are
+// written in CREATE TYPE
+func (c *TypeSchema) rangeOptions(schema string) []string {
+	options := []string{"SUBTYPE = " + remapSchema(c.get("subtype"), c.get("schema_name"), schema)}
+	for _, option := range []struct{ name, key string }{
+		{"SUBTYPE_OPCLASS", "subtype_opclass"},
+		{"COLLATION", "range_collation"},
+		{"CANONICAL", "canonical"},
+		{"SUBTYPE_DIFF", "subtype_diff"},
+	} {
+		if value := c.get(option.key); value != "null" && len(value) > 0 {
+			options = append(options, option.name+" = "+remapSchema(value, c.get("schema_name"), schema))
+		}
+	}
+	if multirange := c.get("multirange_name"); multirange != "null" && len(multirange) > 0 {
+		multirangeSchema := c.get("multirange_schema")
+		if multirangeSchema == c.get("schema_name") {
+			multirangeSchema = schema
+		}
+		options = append(options, "MULTIRANGE_TYPE_NAME = "+qualifiedName(multirangeSchema, multirange))
+	}
+	return options
+}
+
+// dropSql returns the statement that drops the type
+func (c *TypeSchema) dropSql() string {
+	if c.get("type_kind") == "DOMAIN" {
+		return fmt.Sprintf("DROP DOMAIN %s", qualifiedName(c.get("schema_name"), c.get("type_name")))
+	}
+	return fmt.Sprintf("DROP TYPE %s", qualifiedName(c.get("schema_name"), c.get("type_name")))
+}
+
+// Add returns SQL to create the type
+func (c *TypeSchema) Add() *SchemaChange {
+	schema := dbInfo2.DbSchema
+	if schema == "*" {
+		schema = c.get("schema_name")
+	}
+	change := newChange("TYPE", ActionAdd, schema, c.get("type_name"))
+	change.addSql("%s", c.createSql(schema))
+	return change
+}
+
+// Drop returns SQL to drop the type
+func (c *TypeSchema) Drop() *SchemaChange {
+	change := newChange("TYPE", ActionDrop, c.get("schema_name"), c.get("type_name"))
+	change.Destructive = true
+	change.addSql("%s", c.dropSql())
+	return change
+}
+
+// Change alters the type in place where Postgres allows it.  Enum labels are added
+// in their position, composite type attributes are added, dropped, and retyped, and
+// the default, NOT NULL, and constraints of a domain are changed.  Anything else
+// (like a different range subtype) requires dropping and recreating the type.
+func (c *TypeSchema) Change(obj interface{}) *SchemaChange {
+	c2, ok := obj.(*TypeSchema)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error!!!, TypeSchema.Change(obj) needs a TypeSchema instance", c2)
+	}
+	schema := c2.get("schema_name")
+	change := newChange("TYPE", ActionChange, schema, c.get("type_name"))
+	name := qualifiedName(schema, c2.get("type_name"))
+
+	if c.get("type_kind") != c2.get("type_kind") {
+		c.recreate(change, c2, "is a %s in db1 but a %s in db2", c.get("type_kind"), c2.get("type_kind"))
+		return change
+	}
+
+	switch c.get("type_kind") {
+	case "ENUM":
+		statements, ok := enumAddValues(parseArray(c.get("labels")), parseArray(c2.get("labels")))
+		if !ok {
+			change.addWarning("WARNING: The labels of enum %s are %s in db1 and %s in db2.", name, c.get("labels"), c2.get("labels"))
+			change.addWarning("Labels can only be added to an enum.  Removing or reordering them requires recreating the type by hand.")
+		}
+		for _, stmt := range statements {
+			change.addSql("ALTER TYPE %s %s", name, stmt)
+		}
+	case "COMPOSITE":
+		names1, defs1 := c.attributes(schema)
+		names2, defs2 := c2.attributes(schema)
+		for _, attName := range names2 {
+			if _, ok := defs1[attName]; !ok {
+				change.Destructive = true
+				change.addSql("ALTER TYPE %s DROP ATTRIBUTE %s", name, quoteIdent(attName))
+			}
+		}
+		for _, attName := range names1 {
+			def2, ok := defs2[attName]
+			if !ok {
+				change.addSql("ALTER TYPE %s ADD ATTRIBUTE %s %s", name, quoteIdent(attName), defs1[attName])
+			} else if def2 != defs1[attName] {
+				change.addSql("ALTER TYPE %s ALTER ATTRIBUTE %s TYPE %s", name, quoteIdent(attName), defs1[attName])
+			}
+		}
+	case "DOMAIN":
+		if c.baseType(schema) != c2.baseType(schema) || c.get("collation") != c2.get("collation") {
+			c.recreate(change, c2, "is based on %s in db1 but on %s in db2", c.baseType(schema), c2.baseType(schema))
+			return change
+		}
+		if default1 := remapSchema(c.get("default_value"), c.get("schema_name"), schema); default1 != c2.get("default_value") {
+			if default1 == "null" {
+				change.addSql("ALTER DOMAIN %s DROP DEFAULT", name)
+			} else {
+				change.addSql("ALTER DOMAIN %s SET DEFAULT %s", name, default1)
+			}
+		}
+		if c.get("not_null") != c2.get("not_null") {
+			if c.get("not_null") == "true" {
+				change.addSql("ALTER DOMAIN %s SET NOT NULL", name)
+			} else {
+				change.addSql("ALTER DOMAIN %s DROP NOT NULL", name)
+			}
+		}
+		names1, defs1 := c.domainConstraints(schema)
+		names2, defs2 := c2.domainConstraints(schema)
+		for _, conName := range names2 {
+			if defs1[conName] != defs2[conName] {
+				change.addSql("ALTER DOMAIN %s DROP CONSTRAINT %s", name, quoteIdent(conName))
+			}
+		}
+		for _, conName := range names1 {
+			if defs1[conName] != defs2[conName] {
+				change.addSql("ALTER DOMAIN %s ADD CONSTRAINT %s %s", name, quoteIdent(conName), defs1[conName])
+			}
+		}
+	case "RANGE":
+		options1 := strings.Join(c.rangeOptions(schema), ", ")
+		options2 := strings.Join(c2.rangeOptions(schema), ", ")
+		if options1 != options2 {
+			c.recreate(change, c2, "is a range with %s in db1 but %s in db2", options1, options2)
+		}
+	}
+	return change
+}
+
+// recreate adds the SQL to drop the db2 type and create it again like the db1 type
+func (c *TypeSchema) recreate(change *SchemaChange, c2 *TypeSchema, format string, args ...interface{}) {
+	change.Destructive = true
+	change.addWarning("WARNING: Type %s "+format+".", append([]interface{}{qualifiedName(c2.get("schema_name"), c2.get("type_name"))}, args...)...)
+	change.addWarning("It is dropped and recreated, which fails if any columns or functions use it.")
+	change.addSql("%s", c2.dropSql())
+	change.addSql("%s", c.createSql(c2.get("schema_name")))
+}
+
+// enumAddValues returns the ADD VALUE clauses that turn the labels of the db2 enum
+// into the labels of the db1 enum.  Each new label is placed after the label that
+// precedes it in db1 (or before the first label), so the order of the labels is kept.
+// It returns false if that is not possible because db2 has labels that are missing
+// from db1 or that are in a different order.
+func enumAddValues(labels1 []string, labels2 []string) ([]string, bool) {
+	in2 := make(map[string]bool)
+	for _, label := range labels2 {
+		in2[label] = true
+	}
+
+	// The db2 labels must appear in db1 in the same order
+	var common []string
+	for _, label := range labels1 {
+		if in2[label] {
+			common = append(common, label)
+		}
+	}
+	if len(common) != len(labels2) {
+		return nil, false
+	}
+	for i := range common {
+		if common[i] != labels2[i] {
+			return nil, false
+		}
+	}
+
+	var statements []string
+	for i, label := range labels1 {
+		if in2[label] {
+			continue
+		}
+		switch {
+		case i > 0:
+			statements = append(statements, fmt.Sprintf("ADD VALUE %s AFTER %s", quoteLiteral(label), quoteLiteral(labels1[i-1])))
+		case len(labels2) > 0:
+			statements = append(statements, fmt.Sprintf("ADD VALUE %s BEFORE %s", quoteLiteral(label), quoteLiteral(labels2[0])))
+		default:
+			statements = append(statements, fmt.Sprintf("ADD VALUE %s", quoteLiteral(label)))
+		}
+	}
+	return statements, true
+}
+
+// compareTypes returns the changes needed to make the user-defined types match
+// between two databases or schemas
+func compareTypes(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+
+	rows1 := TypeRows(cat1.Query("TYPE", typeSqlTemplate))
+	sort.Sort(rows1)
+
+	rows2 := TypeRows(cat2.Query("TYPE", typeSqlTemplate))
+	sort.Sort(rows2)
+
+	// We have to explicitly type this as Schema here
+	var schema1 Schema = &TypeSchema{rows: rows1, rowNum: -1}
+	var schema2 Schema = &TypeSchema{rows: rows2, rowNum: -1}
+
+	// Compare the types
+	return doDiff(schema1, schema2)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_enumAddValues(t *testing.T) {
+	statements, ok := enumAddValues([]string{"new", "sad", "ok", "meh", "happy", "ecstatic"}, []string{"sad", "ok", "happy"})
+	expected := []string{
+		"ADD VALUE 'new' BEFORE 'sad'",
+		"ADD VALUE 'meh' AFTER 'ok'",
+		"ADD VALUE 'ecstatic' AFTER 'happy'",
+	}
+	if !ok || len(statements) != len(expected) {
+		t.Fatalf("Wrong statements: %q", statements)
+	}
+	for i := range expected {
+		if statements[i] != expected[i] {
+			t.Errorf("Statement %d is %q instead of %q", i, statements[i], expected[i])
+		}
+	}
+
+	// Labels can't be removed or reordered
+	if _, ok := enumAddValues([]string{"a", "b"}, []string{"b", "a"}); ok {
+		t.Error("Reordered labels should not be possible")
+	}
+	if _, ok := enumAddValues([]string{"a"}, []string{"a", "b"}); ok {
+		t.Error("Removed labels should not be possible")
+	}
+}
+
+func Test_TypeSchema(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+
+	enum := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "mood", "type_kind": "ENUM", "labels": `{sad,"it's ok",happy}`},
+	}, rowNum: 0}
+	expectStatements(t, enum.Add(), `CREATE TYPE s1.mood AS ENUM ('sad', 'it''s ok', 'happy')`)
+	expectStatements(t, enum.Drop(), `DROP TYPE s1.mood`)
+
+	composite := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "address", "type_kind": "COMPOSITE",
+			"attribute_names": "{street,zip,Country}", "attribute_types": `{text,"character varying(10)","text COLLATE pg_catalog.\"C\""}`},
+	}, rowNum: 0}
+	expectStatements(t, composite.Add(), `CREATE TYPE s1.address AS (street text, zip character varying(10), "Country" text COLLATE pg_catalog."C")`)
+	composite2 := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "address", "type_kind": "COMPOSITE",
+			"attribute_names": "{street,zip,city}", "attribute_types": "{text,integer,text}"},
+	}, rowNum: 0}
+	expectStatements(t, composite.Change(composite2),
+		`ALTER TYPE s1.address DROP ATTRIBUTE city`,
+		`ALTER TYPE s1.address ALTER ATTRIBUTE zip TYPE character varying(10)`,
+		`ALTER TYPE s1.address ADD ATTRIBUTE "Country" text COLLATE pg_catalog."C"`)
+
+	domain := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "posint", "type_kind": "DOMAIN", "base_type": "integer", "collation": "null",
+			"default_value": "1", "not_null": "true", "constraint_names": "{posint_check}", "constraint_defs": `{"CHECK (VALUE > 0)"}`},
+	}, rowNum: 0}
+	expectStatements(t, domain.Add(), `CREATE DOMAIN s1.posint AS integer DEFAULT 1 NOT NULL CONSTRAINT posint_check CHECK (VALUE > 0)`)
+	expectStatements(t, domain.Drop(), `DROP DOMAIN s1.posint`)
+	domain2 := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "posint", "type_kind": "DOMAIN", "base_type": "integer", "collation": "null",
+			"default_value": "null", "not_null": "false", "constraint_names": "{posint_check}", "constraint_defs": `{"CHECK (VALUE >= 0)"}`},
+	}, rowNum: 0}
+	expectStatements(t, domain.Change(domain2),
+		`ALTER DOMAIN s1.posint SET DEFAULT 1`,
+		`ALTER DOMAIN s1.posint SET NOT NULL`,
+		`ALTER DOMAIN s1.posint DROP CONSTRAINT posint_check`,
+		`ALTER DOMAIN s1.posint ADD CONSTRAINT posint_check CHECK (VALUE > 0)`)
+
+	rng := &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "floatrange", "type_kind": "RANGE", "subtype": "double precision", "subtype_diff": "float8mi",
+			"subtype_opclass": "null", "range_collation": "null", "canonical": "null", "multirange_schema": "s1", "multirange_name": "floatmultirange"},
+	}, rowNum: 0}
+	expectStatements(t, rng.Add(), `CREATE TYPE s1.floatrange AS RANGE (SUBTYPE = double precision, SUBTYPE_DIFF = float8mi, MULTIRANGE_TYPE_NAME = s1.floatmultirange)`)
+
+	rng = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "textrange", "type_kind": "RANGE", "subtype": "text", "subtype_diff": "null",
+			"subtype_opclass": "s1.text_ops_ci", "range_collation": `pg_catalog."C"`, "canonical": "s1.text_canonical",
+			"multirange_schema": "s1", "multirange_name": "textranges"},
+	}, rowNum: 0}
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "s1", "s2"
+	expectStatements(t, rng.Add(), `CREATE TYPE s2.textrange AS RANGE (SUBTYPE = text, SUBTYPE_OPCLASS = s2.text_ops_ci, COLLATION = pg_catalog."C", CANONICAL = s2.text_canonical, MULTIRANGE_TYPE_NAME = s2.textranges)`)
+
+	// Types in the compared schema are remapped wherever they are used
+	composite = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "shipment", "type_kind": "COMPOSITE",
+			"attribute_names": "{address,status}", "attribute_types": "{s1.address,pg_catalog.text}"},
+	}, rowNum: 0}
+	expectStatements(t, composite.Add(), `CREATE TYPE s2.shipment AS (address s2.address, status pg_catalog.text)`)
+	composite2 = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s2", "type_name": "shipment", "type_kind": "COMPOSITE",
+			"attribute_names": "{address,status}", "attribute_types": "{s2.address,pg_catalog.text}"},
+	}, rowNum: 0}
+	if change := composite.Change(composite2); !change.isEmpty() {
+		t.Errorf("Unexpected statements: %q", change.Statements)
+	}
+
+	domain = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "positive_amount", "type_kind": "DOMAIN", "base_type": "s1.amount", "collation": "null",
+			"default_value": "null", "not_null": "false", "constraint_names": "{amount_check}", "constraint_defs": `{"CHECK (s1.is_positive(VALUE))"}`},
+	}, rowNum: 0}
+	expectStatements(t, domain.Add(), `CREATE DOMAIN s2.positive_amount AS s2.amount CONSTRAINT amount_check CHECK (s2.is_positive(VALUE))`)
+	domain2 = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s2", "type_name": "positive_amount", "type_kind": "DOMAIN", "base_type": "s2.amount", "collation": "null",
+			"default_value": "null", "not_null": "false", "constraint_names": "{amount_check}", "constraint_defs": `{"CHECK (s2.is_positive(VALUE))"}`},
+	}, rowNum: 0}
+	if change := domain.Change(domain2); !change.isEmpty() {
+		t.Errorf("Unexpected statements: %q", change.Statements)
+	}
+
+	rng = &TypeSchema{rows: TypeRows{
+		{"schema_name": "s1", "type_name": "amountrange", "type_kind": "RANGE", "subtype": "s1.amount", "subtype_diff": "null",
+			"subtype_opclass": "null", "range_collation": "null", "canonical": "null", "multirange_schema": "null", "multirange_name": "null"},
+	}, rowNum: 0}
+	expectStatements(t, rng.Add(), `CREATE TYPE s2.amountrange AS RANGE (SUBTYPE = s2.amount)`)
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+}
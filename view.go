@@ -8,12 +8,51 @@ package main
 
 import (
 		"fmt"
+		"os"
 		"sort"
-		"database/sql"
-		"github.com/joncrlsn/pgutil"
+		"strings"
+		"text/template"
 		"github.com/joncrlsn/misc"
 )
 
+var (
+	viewSqlTemplate = initViewSqlTemplate()
+)
+
+// Initializes the Sql template
+func initViewSqlTemplate() *template.Template {
+	sql := `
+	SELECT schemaname || '.' || viewname AS viewname
+		, schemaname AS schema_name
+		, viewname AS view_name
+		, definition 
+		-- The columns decide whether CREATE OR REPLACE VIEW can change the view
+		, (SELECT array_agg(quote_ident(a.attname) || ' ' || pg_catalog.format_type(a.atttypid, a.atttypmod) ORDER BY a.attnum)
+		   FROM pg_catalog.pg_attribute AS a
+		   WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped) AS columns
+		-- The views that use this one, which must be recreated when it is
+		, (SELECT array_agg(DISTINCT dn.nspname || '.' || dc.relname)
+		   FROM pg_catalog.pg_depend AS d
+		   INNER JOIN pg_catalog.pg_rewrite AS r ON (r.oid = d.objid)
+		   INNER JOIN pg_catalog.pg_class AS dc ON (dc.oid = r.ev_class)
+		   INNER JOIN pg_catalog.pg_namespace AS dn ON (dn.oid = dc.relnamespace)
+		   WHERE d.classid = 'pg_rewrite'::regclass
+		   AND d.refclassid = 'pg_class'::regclass
+		   AND d.refobjid = c.oid
+		   AND dc.oid <> c.oid
+		   AND dc.relkind = 'v') AS dependent_views
+	FROM pg_views 
+	INNER JOIN pg_catalog.pg_namespace AS n ON (n.nspname = schemaname)
+	INNER JOIN pg_catalog.pg_class AS c ON (c.relnamespace = n.oid AND c.relname = viewname)
+	WHERE {{$.SchemaFilter "schemaname"}}
+	AND {{$.ExtensionFilter "pg_class" "c.oid"}}
+	ORDER BY viewname;
+	`
+	t := template.New("ViewSqlTmpl")
+	template.Must(t.Parse(sql))
+	return t
+}
+
 // ==================================
 // ViewRows definition
 // ==================================
@@ -38,9 +77,10 @@ func (slice ViewRows) Swap(i, j int) {
 //
 // ViewSchema implements the Schema interface defined in pgdiff.go
 type ViewSchema struct {
-	rows   ViewRows
-	rowNum int
-	done   bool
+	rows     ViewRows
+	rowNum   int
+	done     bool
+	recreate map[string]bool // the views that must be dropped and created again
 }
 
 // get returns the value from the current row for the given key
@@ -64,7 +104,7 @@ func (c *ViewSchema) NextRow() bool {
 func (c *ViewSchema) Compare(obj interface{}) int {
 	c2, ok := obj.(*ViewSchema)
 	if !ok {
-		fmt.Println("Error!!!, Compare(obj) needs a ViewSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Compare(obj) needs a ViewSchema instance", c2)
 		return +999
 	}
 
@@ -73,57 +113,135 @@ func (c *ViewSchema) Compare(obj interface{}) int {
 	return val
 }
 
+// ObjectKey identifies the view in the current row when ordering by dependency
+func (c *ViewSchema) ObjectKey() string {
+	return "view:" + c.get("viewname")
+}
+
+// Row returns the current row, or nil when there isn't one
+func (c *ViewSchema) Row() map[string]string {
+	if c.rowNum < 0 || c.rowNum >= len(c.rows) {
+		return nil
+	}
+	return c.rows[c.rowNum]
+}
+
 // Add returns SQL to create the view
-func (c ViewSchema) Add() {
-	fmt.Printf("CREATE VIEW %s AS %s \n\n", c.get("viewname"), c.get("definition"))
+func (c ViewSchema) Add() *SchemaChange {
+	change := newChange("VIEW", ActionAdd, "", c.get("viewname"))
+	change.addSql("CREATE VIEW %s AS %s", qualifiedName(c.get("schema_name"), c.get("view_name")), viewDefinition(c.get("definition")))
+	return change
 }
 
 // Drop returns SQL to drop the view
-func (c ViewSchema) Drop() {
-	fmt.Printf("DROP VIEW %s;\n\n", c.get("viewname"))
+func (c ViewSchema) Drop() *SchemaChange {
+	change := newChange("VIEW", ActionDrop, "", c.get("viewname"))
+	change.Destructive = true
+	change.addSql("DROP VIEW %s", qualifiedName(c.get("schema_name"), c.get("view_name")))
+	return change
 }
 
 // Change handles the case where the names match, but the definition does not
-func (c ViewSchema) Change(obj interface{}) {
+func (c ViewSchema) Change(obj interface{}) *SchemaChange {
 	c2, ok := obj.(*ViewSchema)
 	if !ok {
-		fmt.Println("Error!!!, Change needs a ViewSchema instance", c2)
+		fmt.Fprintln(os.Stderr, "Error!!!, Change needs a ViewSchema instance", c2)
 	}
-	if c.get("definition") != c2.get("definition") {
-		fmt.Printf("DROP VIEW %s;\n", c.get("viewname"))
-		fmt.Printf("CREATE VIEW %s AS %s \n\n", c.get("viewname"), c.get("definition"))
+	change := newChange("VIEW", ActionChange, "", c.get("viewname"))
+	if c.recreate[c.get("viewname")] {
+		// compareViews drops it with the other drops
+		change.addSql("CREATE VIEW %s AS %s", qualifiedName(c.get("schema_name"), c.get("view_name")), viewDefinition(c.get("definition")))
+	} else if c.get("definition") != c2.get("definition") {
+		change.addSql("CREATE OR REPLACE VIEW %s AS %s", qualifiedName(c.get("schema_name"), c.get("view_name")), viewDefinition(c.get("definition")))
 	}
+	return change
 }
 
-// compareViews outputs SQL to make the views match between DBs
-func compareViews(conn1 *sql.DB, conn2 *sql.DB) {
-	sql := `
-	SELECT schemaname || '.' || viewname AS viewname
-		, definition 
-	FROM pg_views 
-	WHERE schemaname NOT LIKE 'pg_%' AND schemaname!='infromation_schema'
-	ORDER BY viewname;
-	`
+// canReplace tells you whether CREATE OR REPLACE VIEW can turn the db2 view into the
+// db1 view.  The db2 columns must come first with the same names and types.
+func (c *ViewSchema) canReplace(c2 *ViewSchema) bool {
+	columns1, columns2 := parseArray(c.get("columns")), parseArray(c2.get("columns"))
+	if len(columns2) > len(columns1) {
+		return false
+	}
+	for i := range columns2 {
+		if columns1[i] != columns2[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	rowChan1, _ := pgutil.QueryStrings(conn1, sql)
-	rowChan2, _ := pgutil.QueryStrings(conn2, sql)
+// viewsToRecreate returns the db2 views that must be dropped and created again: the
+// changed views that CREATE OR REPLACE VIEW can't change, and the views in db2 that
+// use them (which would keep them from being dropped).  Views that aren't in db1 are
+// dropped anyway, so they are left out.
+func viewsToRecreate(rows1 ViewRows, rows2 ViewRows) (names []string, recreate map[string]bool) {
+	views1, views2 := make(map[string]*ViewSchema), make(map[string]*ViewSchema)
+	for i, row := range rows1 {
+		views1[row["viewname"]] = &ViewSchema{rows: rows1, rowNum: i}
+	}
+	for i, row := range rows2 {
+		views2[row["viewname"]] = &ViewSchema{rows: rows2, rowNum: i}
+	}
 
-	rows1 := make(ViewRows, 0)
-	for row := range rowChan1 {
-		rows1 = append(rows1, row)
+	recreate = make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		if recreate[name] || views1[name] == nil {
+			return
+		}
+		recreate[name] = true
+		for _, dependent := range parseArray(views2[name].get("dependent_views")) {
+			add(dependent)
+		}
+		// Dependent views are dropped first
+		names = append(names, name)
 	}
+	for _, row := range rows2 {
+		name := row["viewname"]
+		v1, v2 := views1[name], views2[name]
+		if v1 != nil && v1.get("definition") != v2.get("definition") && !v1.canReplace(v2) {
+			add(name)
+		}
+	}
+	return names, recreate
+}
+
+// compareViews returns the changes needed to make the views match between DBs
+func compareViews(cat1 Catalog, cat2 Catalog) []*SchemaChange {
+	rows1 := ViewRows(cat1.Query("VIEW", viewSqlTemplate))
 	sort.Sort(rows1)
 
-	rows2 := make(ViewRows, 0)
-	for row := range rowChan2 {
-		rows2 = append(rows2, row)
-	}
+	rows2 := ViewRows(cat2.Query("VIEW", viewSqlTemplate))
 	sort.Sort(rows2)
 
+	names, recreate := viewsToRecreate(rows1, rows2)
+
 	// We have to explicitly type this as Schema here
-	var schema1 Schema = &ViewSchema{rows: rows1, rowNum: -1}
-	var schema2 Schema = &ViewSchema{rows: rows2, rowNum: -1}
+	var schema1 Schema = &ViewSchema{rows: rows1, rowNum: -1, recreate: recreate}
+	var schema2 Schema = &ViewSchema{rows: rows2, rowNum: -1, recreate: recreate}
+
+	// The views that are created again are dropped like any other view, so that with
+	// ALL they are dropped in the drop phase (in the order of the db2 dependencies)
+	var changes []*SchemaChange
+	for _, name := range names {
+		for _, row := range rows2 {
+			if row["viewname"] == name {
+				change := (&ViewSchema{rows: ViewRows{row}, rowNum: 0}).Drop()
+				change.key = "view:" + name
+				change.DB2 = row
+				changes = append(changes, change)
+			}
+		}
+	}
 
 	// Compare the views
-	doDiff(schema1, schema2)
+	return append(changes, doDiff(schema1, schema2)...)
+}
+
+// viewDefinition trims the trailing semicolon that pg_views and pg_matviews include
+// in a view definition
+func viewDefinition(definition string) string {
+	return strings.TrimSuffix(strings.TrimSpace(definition), ";")
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_compareViews(t *testing.T) {
+	dbInfo1.DbSchema, dbInfo2.DbSchema = "*", "*"
+	row := func(name, definition, columns, dependents string) map[string]string {
+		return map[string]string{"viewname": "s1." + name, "schema_name": "s1", "view_name": name,
+			"definition": definition, "columns": columns, "dependent_views": dependents}
+	}
+	cat1 := testCatalog{"VIEW": {
+		row("v1", " SELECT t.id, t.name FROM s1.t;", "{\"id integer\",\"name text\"}", "null"),
+		row("v2", " SELECT v1.id FROM s1.v1;", "{\"id integer\"}", "null"),
+		row("v3", " SELECT t.id::bigint AS id FROM s1.t;", "{\"id bigint\"}", "null"),
+		row("v4", " SELECT v3.id FROM s1.v3;", "{\"id bigint\"}", "null"),
+	}}
+	cat2 := testCatalog{"VIEW": {
+		row("v1", " SELECT t.id FROM s1.t;", "{\"id integer\"}", "{s1.v2}"),
+		row("v2", " SELECT v1.id FROM s1.v1;", "{\"id integer\"}", "null"),
+		row("v3", " SELECT t.id FROM s1.t;", "{\"id integer\"}", "{s1.v4}"),
+		row("v4", " SELECT v3.id FROM s1.v3;", "{\"id integer\"}", "null"),
+	}}
+
+	// v1 only gains a column, so it is replaced.  v3 changes the type of its column, so
+	// it is dropped after v4, which uses it, and both are created again.
+	changes := compareViews(cat1, cat2)
+	var statements []string
+	for _, change := range changes {
+		statements = append(statements, change.Statements...)
+		if change.Action == ActionDrop && (!change.Destructive || change.key != "view:"+change.Name) {
+			t.Errorf("Wrong drop of %s: %+v", change.Name, change)
+		}
+	}
+	expected := []string{
+		"DROP VIEW s1.v4",
+		"DROP VIEW s1.v3",
+		"CREATE OR REPLACE VIEW s1.v1 AS SELECT t.id, t.name FROM s1.t",
+		"CREATE VIEW s1.v3 AS SELECT t.id::bigint AS id FROM s1.t",
+		"CREATE VIEW s1.v4 AS SELECT v3.id FROM s1.v3",
+	}
+	if len(statements) != len(expected) {
+		t.Fatalf("Wrong statements: %q", statements)
+	}
+	for i := range expected {
+		if statements[i] != expected[i] {
+			t.Errorf("Wrong statement %d: %q instead of %q", i, statements[i], expected[i])
+		}
+	}
+}